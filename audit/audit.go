@@ -0,0 +1,304 @@
+// Package audit writes a JSON line for every cryptographic operation the
+// API performs. Events name the caller, keys and outcome of an operation,
+// never the secrets themselves. With hash chaining each line carries the
+// SHA-256 of the previous line's hash and its own contents, so edits to or
+// removal of earlier lines are detected by Verify.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher/secrets-api/pkg/logutils"
+	"github.com/rancher/secrets-api/policy"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeError   = "error"
+
+	// TargetSyslog sends events to the local syslog daemon instead of a file
+	TargetSyslog = "syslog"
+	// TargetStdout writes events to standard output
+	TargetStdout = "-"
+)
+
+var log = logutils.New("audit")
+
+// hashSuffix matches the hash a chained line ends with
+var hashSuffix = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+// Key is a backend key an operation used
+type Key struct {
+	Backend string `json:"backend"`
+	KeyName string `json:"keyName"`
+	// Count is the number of secrets of a bulk request that used the key
+	Count int `json:"count,omitempty"`
+}
+
+// Event is one audited operation
+type Event struct {
+	Time      string           `json:"time"`
+	RequestID string           `json:"requestId,omitempty"`
+	Caller    *policy.Identity `json:"caller"`
+	Action    string           `json:"action"`
+	Bulk      bool             `json:"bulk,omitempty"`
+	Keys      []Key            `json:"keys"`
+	// TargetKeys are the keys a migration sealed the secrets with
+	TargetKeys []Key `json:"targetKeys,omitempty"`
+	// RewrapKey is the fingerprint of the public key secrets were rewrapped to
+	RewrapKey string `json:"rewrapKey,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	// Path is the request path of requests rejected before reaching the API
+	Path string `json:"path,omitempty"`
+}
+
+// AddKey counts a secret that used backend and keyName
+func AddKey(keys []Key, backend, keyName string) []Key {
+	for i := range keys {
+		if keys[i].Backend == backend && keys[i].KeyName == keyName {
+			keys[i].Count++
+			return keys
+		}
+	}
+
+	return append(keys, Key{Backend: backend, KeyName: keyName, Count: 1})
+}
+
+// Logger writes events as JSON lines
+type Logger struct {
+	lock   sync.Mutex
+	out    io.WriteCloser
+	chain  bool
+	last   string
+	failed bool
+}
+
+// NewLogger writes events to out. With chain set, each line is hashed
+// together with last, the hash of the line before it.
+func NewLogger(out io.WriteCloser, chain bool, last string) *Logger {
+	return &Logger{
+		out:   out,
+		chain: chain,
+		last:  last,
+	}
+}
+
+// Open writes events to target, a file path, TargetSyslog or TargetStdout.
+// Files are appended to and a chain continues from their last line.
+func Open(target string, chain bool) (*Logger, error) {
+	switch target {
+	case TargetSyslog:
+		out, err := openSyslog()
+		if err != nil {
+			return nil, err
+		}
+		return NewLogger(out, chain, ""), nil
+	case TargetStdout:
+		return NewLogger(nopCloser{os.Stdout}, chain, ""), nil
+	}
+
+	file, err := os.OpenFile(target, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := lastHash(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Audit log %s: %v", target, err)
+	}
+
+	return NewLogger(file, chain, last), nil
+}
+
+// Record writes an event, stamping its time when not set
+func (l *Logger) Record(event *Event) error {
+	if event.Time == "" {
+		event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	hash := ""
+	if l.chain {
+		hash = chainHash(l.last, line)
+		line = append(line[:len(line)-1], []byte(`,"hash":"`+hash+`"}`)...)
+	}
+
+	if _, err := l.out.Write(append(line, '\n')); err != nil {
+		if !l.failed {
+			log.Errorf("Could not write audit event: %v", err)
+		}
+		l.failed = true
+		return err
+	}
+	l.failed = false
+
+	// The chain only moves on once the line is written, so a failed write
+	// does not break it for the lines after
+	if l.chain {
+		l.last = hash
+	}
+
+	return nil
+}
+
+// Close closes the underlying file or syslog connection
+func (l *Logger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.out.Close()
+}
+
+func chainHash(previous string, line []byte) string {
+	h := sha256.New()
+	h.Write([]byte(previous))
+	h.Write(line)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks the hash chain of an audit log. Lines written before
+// chaining was enabled are skipped; after the first chained line every line
+// must continue the chain. It returns the number of chained lines.
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	previous := ""
+	chained := 0
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		match := hashSuffix.FindSubmatchIndex(line)
+		if match == nil {
+			if chained > 0 {
+				return chained, fmt.Errorf("Line %d is not chained", lineNumber)
+			}
+			continue
+		}
+
+		hash := string(line[match[2]:match[3]])
+		body := append(append([]byte{}, line[:match[0]]...), '}')
+		if chainHash(previous, body) != hash {
+			return chained, fmt.Errorf("Line %d does not match the chain, the log was modified at or before it", lineNumber)
+		}
+
+		previous = hash
+		chained++
+	}
+
+	return chained, scanner.Err()
+}
+
+// lastHash returns the hash of the last line of an audit file, or "" when
+// the file is empty or its last line is not chained
+func lastHash(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Read backwards until a whole line is in the buffer
+	size := info.Size()
+	var tail []byte
+	for chunk := int64(4096); ; chunk *= 2 {
+		if chunk > size {
+			chunk = size
+		}
+
+		tail = make([]byte, chunk)
+		if _, err := file.ReadAt(tail, size-chunk); err != nil && err != io.EOF {
+			return "", err
+		}
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if chunk == size || bytes.LastIndexByte(trimmed, '\n') >= 0 {
+			tail = trimmed[bytes.LastIndexByte(trimmed, '\n')+1:]
+			break
+		}
+	}
+
+	if match := hashSuffix.FindSubmatch(tail); match != nil {
+		return string(match[1]), nil
+	}
+
+	return "", nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+var (
+	currentLock sync.RWMutex
+	current     *Logger
+
+	// failures counts the events Record could not write
+	failures uint64
+)
+
+// SetLogger sets the logger Record writes to, nil disables auditing
+func SetLogger(logger *Logger) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+
+	current = logger
+}
+
+// Enabled reports whether events are being recorded
+func Enabled() bool {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+
+	return current != nil
+}
+
+// Record writes an event to the logger set with SetLogger
+func Record(event *Event) error {
+	currentLock.RLock()
+	logger := current
+	currentLock.RUnlock()
+
+	if logger == nil {
+		return nil
+	}
+
+	if err := logger.Record(event); err != nil {
+		atomic.AddUint64(&failures, 1)
+		return err
+	}
+
+	return nil
+}
+
+// Failures returns the number of events Record could not write
+func Failures() uint64 {
+	return atomic.LoadUint64(&failures)
+}
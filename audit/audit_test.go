@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/rancher/secrets-api/policy"
+)
+
+func TestHashChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := path.Join(dir, "audit.log")
+
+	// A line written before chaining was turned on is skipped by Verify
+	unchained, err := Open(logPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchained.Record(&Event{Action: policy.ActionCreate, Keys: []Key{}, Outcome: OutcomeSuccess})
+	unchained.Close()
+
+	caller := &policy.Identity{Method: "token", Subject: "ci"}
+	for i := 0; i < 2; i++ {
+		// Reopening continues the chain from the last line
+		logger, err := Open(logPath, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, action := range []string{policy.ActionCreate, policy.ActionRewrap} {
+			event := &Event{RequestID: "req-1", Caller: caller, Action: action, Keys: AddKey(nil, "vault", "app"), Outcome: OutcomeSuccess}
+			if err := logger.Record(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		logger.Close()
+	}
+
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 lines, got %d", len(lines))
+	}
+
+	event := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event["requestId"] != "req-1" || event["time"] == "" || event["hash"] == nil {
+		t.Errorf("Unexpected event %v", event)
+	}
+
+	chained, err := Verify(bytes.NewReader(data))
+	if err != nil || chained != 4 {
+		t.Errorf("Expected 4 verified lines, got %d: %v", chained, err)
+	}
+
+	for name, tampered := range map[string][]string{
+		"edited line":   {lines[0], lines[1], strings.Replace(lines[2], `"outcome":"success"`, `"outcome":"denied"`, 1), lines[3], lines[4]},
+		"removed line":  {lines[0], lines[1], lines[3], lines[4]},
+		"swapped lines": {lines[0], lines[2], lines[1], lines[3], lines[4]},
+		"unchained":     {lines[0], lines[1], lines[2], lines[0], lines[3]},
+	} {
+		if _, err := Verify(strings.NewReader(strings.Join(tampered, "\n"))); err == nil {
+			t.Errorf("Expected Verify to detect the %s", name)
+		}
+	}
+}
+
+// failingWriter fails the writes while fail is set
+type failingWriter struct {
+	bytes.Buffer
+	fail bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("disk full")
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *failingWriter) Close() error {
+	return nil
+}
+
+func TestFailedWriteKeepsChain(t *testing.T) {
+	out := &failingWriter{}
+	logger := NewLogger(out, true, "")
+
+	for _, fail := range []bool{false, true, false} {
+		out.fail = fail
+		err := logger.Record(&Event{Action: policy.ActionCreate, Keys: []Key{}, Outcome: OutcomeSuccess})
+		if fail != (err != nil) {
+			t.Errorf("Expected a write error only when the write fails, got %v", err)
+		}
+	}
+
+	if chained, err := Verify(bytes.NewReader(out.Bytes())); err != nil || chained != 2 {
+		t.Errorf("Expected 2 verified lines, got %d: %v", chained, err)
+	}
+
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	failed := Failures()
+	out.fail = true
+	if err := Record(&Event{Action: policy.ActionCreate, Keys: []Key{}, Outcome: OutcomeSuccess}); err == nil {
+		t.Error("Expected Record to return the write error")
+	}
+	if Failures() != failed+1 {
+		t.Errorf("Expected the failure to be counted, got %d", Failures())
+	}
+}
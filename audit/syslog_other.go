@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog() (io.WriteCloser, error) {
+	return nil, errors.New("Syslog is not supported on this platform")
+}
@@ -0,0 +1,100 @@
+package backends
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/secrets-api/backends/kms"
+	"github.com/rancher/secrets-api/backends/localkey"
+	"github.com/rancher/secrets-api/backends/none"
+	"github.com/rancher/secrets-api/backends/vault"
+)
+
+func init() {
+	Register("none", noneFactory{})
+	Register("localkey", localkeyFactory{})
+	Register("vault", vaultFactory{})
+	Register("kms", kmsFactory{})
+}
+
+type noneFactory struct{}
+
+func (noneFactory) Configured(config ConfigSection) bool {
+	return true
+}
+
+func (noneFactory) New(config ConfigSection) (EncryptorClient, error) {
+	return &none.Client{}, nil
+}
+
+type localkeyFactory struct{}
+
+func (localkeyFactory) Configured(config ConfigSection) bool {
+	return config.Get("keyPath") != ""
+}
+
+func (localkeyFactory) New(config ConfigSection) (EncryptorClient, error) {
+	interval := localkey.DefaultReloadInterval
+	if value := config.Get("reloadInterval"); value != "" {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("Invalid reloadInterval: %v", err)
+		}
+	}
+
+	return localkey.NewLocalKeyWithReload(config.Get("keyPath"), interval)
+}
+
+type vaultFactory struct{}
+
+func (vaultFactory) Configured(config ConfigSection) bool {
+	return config.Get("url") != "" &&
+		(config.Get("token") != "" || config.Get("approleRoleIdFile") != "" || config.Get("kubernetesRole") != "")
+}
+
+func (vaultFactory) New(config ConfigSection) (EncryptorClient, error) {
+	vaultConfig := vault.Config{
+		URL:   config.Get("url"),
+		Token: config.Get("token"),
+	}
+
+	if config.Get("approleRoleIdFile") != "" {
+		vaultConfig.AppRole = &vault.AppRoleAuth{
+			RoleIDFile:   config.Get("approleRoleIdFile"),
+			SecretIDFile: config.Get("approleSecretIdFile"),
+			MountPath:    config.Get("approleMount"),
+		}
+	}
+
+	if config.Get("kubernetesRole") != "" {
+		vaultConfig.Kubernetes = &vault.KubernetesAuth{
+			Role:      config.Get("kubernetesRole"),
+			JWTFile:   config.Get("kubernetesJwtFile"),
+			MountPath: config.Get("kubernetesMount"),
+		}
+	}
+
+	return vault.NewClientWithConfig(vaultConfig)
+}
+
+type kmsFactory struct{}
+
+func (kmsFactory) Configured(config ConfigSection) bool {
+	return config.Get("region") != ""
+}
+
+func (kmsFactory) New(config ConfigSection) (EncryptorClient, error) {
+	return kms.NewClient(kms.Config{
+		Region:   config.Get("region"),
+		Endpoint: config.Get("endpoint"),
+		Credentials: kms.Credentials{
+			AccessKeyID:     config.Get("accessKeyId"),
+			SecretAccessKey: config.Get("secretAccessKey"),
+			SessionToken:    config.Get("sessionToken"),
+		},
+		STSEndpoint:      config.Get("stsEndpoint"),
+		MetadataEndpoint: config.Get("metadataEndpoint"),
+		SigningKey:       config.Get("signingKey"),
+		SigningAlgorithm: config.Get("signingAlgorithm"),
+	})
+}
@@ -0,0 +1,35 @@
+//go:build pkcs11
+// +build pkcs11
+
+package backends
+
+import (
+	"strconv"
+
+	"github.com/rancher/secrets-api/backends/pkcs11"
+)
+
+func init() {
+	Register("pkcs11", pkcs11Factory{})
+}
+
+type pkcs11Factory struct{}
+
+func (pkcs11Factory) Configured(config ConfigSection) bool {
+	return config.Get("module") != ""
+}
+
+func (pkcs11Factory) New(config ConfigSection) (EncryptorClient, error) {
+	slot, err := strconv.ParseUint(config.Get("slot"), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkcs11.NewClient(pkcs11.Config{
+		ModulePath:   config.Get("module"),
+		Slot:         uint(slot),
+		PIN:          config.Get("pin"),
+		KeyLabel:     config.Get("keyLabel"),
+		HMACKeyLabel: config.Get("hmacKeyLabel"),
+	})
+}
@@ -2,13 +2,39 @@ package backends
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	configsLock    sync.RWMutex
+	runtimeConfigs = NewConfig()
+
+	registryLock sync.RWMutex
+	registry     = map[string]Factory{}
 
-	"github.com/rancher/secrets-api/backends/localkey"
-	"github.com/rancher/secrets-api/backends/none"
-	"github.com/rancher/secrets-api/backends/vault"
+	// Clients are created once per config and shared between requests
+	clientsLock sync.Mutex
+	clients     = map[string]*sharedClient{}
+
+	// retryFailedAfter is how long a failure to create a client is returned
+	// to callers before the next attempt, so a backend that is down is not
+	// logged in to on every request
+	retryFailedAfter = 10 * time.Second
 )
 
-var runtimeConfigs *Configs
+// sharedClient is the client of a backend, or the error creating it. done is
+// closed once the factory returned, callers asking for the client meanwhile
+// wait for that single attempt.
+type sharedClient struct {
+	done     chan struct{}
+	client   EncryptorClient
+	err      error
+	failedAt time.Time
+}
 
 // EncryptorClient defines the interface for backend encryption clients
 type EncryptorClient interface {
@@ -19,22 +45,255 @@ type EncryptorClient interface {
 	Delete(keyName, cipherText string) error
 }
 
-// New returns an encrytion client of a specific type
-func New(name string) (EncryptorClient, error) {
-	switch name {
-	case "none":
-		return &none.Client{}, nil
-	case "localkey":
-		if runtimeConfigs.EncryptionKeyPath != "" {
-			return localkey.NewLocalKey(runtimeConfigs.EncryptionKeyPath)
-		}
-		return nil, errors.New("No backend configured")
-	case "vault":
-		if runtimeConfigs.VaultURL != "" && runtimeConfigs.VaultToken != "" {
-			return vault.NewClient(runtimeConfigs.VaultURL, runtimeConfigs.VaultToken)
-		}
-		return nil, errors.New("Backend not configured")
+// BatchEncryptor is implemented by clients that can handle many secrets of
+// the same key in a single round trip. Results are in the order of the input.
+type BatchEncryptor interface {
+	BatchGetEncryptedText(keyName string, clearTexts []string) ([]string, error)
+	BatchGetClearText(keyName string, cipherTexts []string) ([]string, error)
+	BatchSign(keyName string, clearTexts []string) ([]string, error)
+	BatchVerifySignature(keyName string, signatures, messages []string) ([]bool, error)
+}
+
+// EnvelopeSigner is implemented by clients that sign the blob returned by
+// GetEncryptedText with its data key, so that the master key is never used on
+// the secret itself. The secret is not decrypted to verify the signature.
+// Signatures for which EnvelopeSigned is false were made with Sign and are
+// checked with VerifySignature.
+type EnvelopeSigner interface {
+	SignEnvelope(keyName, cipherText string) (string, error)
+	VerifyEnvelope(keyName, signature, cipherText string) (bool, error)
+	EnvelopeSigned(signature string) bool
+}
+
+// DataKeyRewrapper is implemented by clients that seal secrets with a data
+// key. RewrapDataKey wraps the data key again with the newest version of the
+// master key and leaves the sealed secret and its envelope signature as is.
+type DataKeyRewrapper interface {
+	RewrapDataKey(keyName, cipherText string) (string, error)
+}
+
+// Validator is implemented by clients that can check more than their config
+// at startup, such as the key material they will use
+type Validator interface {
+	Validate() error
+}
+
+// HealthChecker is implemented by clients whose state can degrade after
+// startup, such as an expiring token
+type HealthChecker interface {
+	Health() error
+}
+
+// Factory creates encryption clients for a registered backend from its
+// config section
+type Factory interface {
+	// Configured reports whether the section holds enough to create a client
+	Configured(config ConfigSection) bool
+	New(config ConfigSection) (EncryptorClient, error)
+}
+
+// Register makes a backend available by name. Backends compiled in from
+// outside this package call it from an init function.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if factory == nil {
+		panic("backends: Register factory is nil for " + name)
+	}
+
+	if _, exists := registry[name]; exists {
+		panic("backends: Register called twice for " + name)
+	}
+
+	registry[name] = factory
+}
+
+// Registered returns the sorted names of all registered backends
+func Registered() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// IsConfigured reports whether a registered backend has a usable config
+func IsConfigured(name string) bool {
+	factory, ok := getFactory(name)
+	if !ok {
+		return false
+	}
+
+	return factory.Configured(currentConfigs().Section(name))
+}
+
+// Validate creates a client for every configured backend so that bad
+// configuration is reported at startup instead of on the first request
+func Validate() error {
+	for _, name := range currentConfigs().Sections() {
+		if _, ok := getFactory(name); !ok {
+			return fmt.Errorf("Configuration given for unknown backend: %s", name)
+		}
+	}
+
+	for _, name := range Registered() {
+		if !IsConfigured(name) {
+			continue
+		}
+
+		client, err := New(name)
+		if err != nil {
+			return fmt.Errorf("Backend %s is misconfigured: %v", name, err)
+		}
+
+		if validator, ok := client.(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return fmt.Errorf("Backend %s failed validation: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Health reports whether the client of a backend can serve requests. It
+// never creates a client, created is false when no request has needed one
+// yet or creating it failed.
+func Health(name string) (created bool, err error) {
+	clientsLock.Lock()
+	shared, ok := clients[name]
+	clientsLock.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	select {
+	case <-shared.done:
 	default:
+		return false, nil
+	}
+
+	if shared.err != nil {
+		return false, nil
+	}
+
+	if checker, ok := shared.client.(HealthChecker); ok {
+		return true, checker.Health()
+	}
+
+	return true, nil
+}
+
+// New returns the encrytion client of a specific type. The client is created
+// on first use and shared until the backend configs change, so clients must be
+// safe for concurrent use. Clients are created without holding the lock of
+// the other backends, so a slow or unreachable backend only delays its own
+// callers.
+func New(name string) (EncryptorClient, error) {
+	factory, ok := getFactory(name)
+	if !ok {
 		return nil, errors.New("Unknown Encryption backend")
 	}
+
+	for {
+		clientsLock.Lock()
+		shared, existing := clients[name]
+		if !existing {
+			config := currentConfigs().Section(name)
+			if !factory.Configured(config) {
+				clientsLock.Unlock()
+				return nil, errors.New("Backend not configured")
+			}
+
+			shared = &sharedClient{done: make(chan struct{})}
+			clients[name] = shared
+			clientsLock.Unlock()
+
+			shared.create(factory, config)
+		} else {
+			clientsLock.Unlock()
+			<-shared.done
+		}
+
+		// A failure is returned as is to the caller that just created it,
+		// and to others until it is old enough to try again
+		clientsLock.Lock()
+		current := clients[name] == shared
+		if current && existing && shared.err != nil && time.Since(shared.failedAt) >= retryFailedAfter {
+			delete(clients, name)
+			current = false
+		}
+		clientsLock.Unlock()
+
+		if current {
+			return shared.client, shared.err
+		}
+
+		// A client created for configs that were replaced meanwhile is
+		// not shared, so it is closed here
+		if !existing {
+			shared.close()
+		}
+	}
+}
+
+func (s *sharedClient) create(factory Factory, config ConfigSection) {
+	defer close(s.done)
+
+	s.client, s.err = factory.New(config)
+	if s.err != nil {
+		s.failedAt = time.Now()
+	}
+}
+
+// close releases the resources of a client that is no longer shared. A
+// client still being created is closed by the caller creating it.
+func (s *sharedClient) close() {
+	select {
+	case <-s.done:
+	default:
+		return
+	}
+
+	if closer, ok := s.client.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// replaceConfigs swaps in new configs and drops the shared clients, closing
+// those that hold resources
+func replaceConfigs(config *Configs) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	for name, shared := range clients {
+		shared.close()
+		delete(clients, name)
+	}
+
+	configsLock.Lock()
+	runtimeConfigs = config
+	configsLock.Unlock()
+}
+
+func currentConfigs() *Configs {
+	configsLock.RLock()
+	defer configsLock.RUnlock()
+
+	return runtimeConfigs
+}
+
+func getFactory(name string) (Factory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	factory, ok := registry[name]
+	return factory, ok
 }
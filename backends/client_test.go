@@ -0,0 +1,290 @@
+package backends
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rancher/secrets-api/backends/none"
+)
+
+// testFactory counts the clients it creates. Clients are none clients unless
+// fail is set, and New blocks while block is open.
+type testFactory struct {
+	created int32
+	fail    atomic.Value
+	block   chan struct{}
+	closed  int32
+}
+
+// closingClient records being closed with its factory
+type closingClient struct {
+	none.Client
+	factory *testFactory
+}
+
+func (c *closingClient) Close() error {
+	atomic.AddInt32(&c.factory.closed, 1)
+	return nil
+}
+
+// invalidClient fails the startup validation
+type invalidClient struct {
+	none.Client
+}
+
+func (c *invalidClient) Validate() error {
+	return errors.New("Key material missing")
+}
+
+func (f *testFactory) Configured(config ConfigSection) bool {
+	return config.Get("enabled") != ""
+}
+
+func (f *testFactory) New(config ConfigSection) (EncryptorClient, error) {
+	atomic.AddInt32(&f.created, 1)
+	if f.block != nil {
+		<-f.block
+	}
+
+	if fail, _ := f.fail.Load().(bool); fail {
+		return nil, errors.New("Backend is down")
+	}
+
+	switch config.Get("client") {
+	case "closing":
+		return &closingClient{factory: f}, nil
+	case "invalid":
+		return &invalidClient{}, nil
+	}
+
+	return &none.Client{}, nil
+}
+
+func (f *testFactory) count() int {
+	return int(atomic.LoadInt32(&f.created))
+}
+
+// registerTest registers a factory for the duration of the test
+func registerTest(t *testing.T, name string, factory Factory) {
+	Register(name, factory)
+	t.Cleanup(func() {
+		registryLock.Lock()
+		delete(registry, name)
+		registryLock.Unlock()
+	})
+}
+
+func setTestConfigs(t *testing.T, names ...string) {
+	config := NewConfig()
+	for _, name := range names {
+		config.Set(name, "enabled", "true")
+	}
+
+	if err := SetBackendConfigs(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewDoesNotBlockOtherBackends(t *testing.T) {
+	slow := &testFactory{block: make(chan struct{})}
+	fast := &testFactory{}
+	registerTest(t, "test-slow", slow)
+	registerTest(t, "test-fast", fast)
+
+	setTestConfigs(t, "test-slow", "test-fast")
+	defer setTestConfigs(t)
+
+	// Callers of the slow backend share a single attempt
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := New("test-slow"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := New("test-fast")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Creating a client was blocked by another backend")
+	}
+
+	close(slow.block)
+	wg.Wait()
+
+	if slow.count() != 1 {
+		t.Errorf("Expected one client to be created for concurrent callers, got %d", slow.count())
+	}
+}
+
+func TestNewRetriesFailuresLater(t *testing.T) {
+	factory := &testFactory{}
+	factory.fail.Store(true)
+	registerTest(t, "test-failing", factory)
+
+	setTestConfigs(t, "test-failing")
+	defer setTestConfigs(t)
+
+	defer func(retry time.Duration) { retryFailedAfter = retry }(retryFailedAfter)
+	retryFailedAfter = time.Hour
+
+	for i := 0; i < 3; i++ {
+		if _, err := New("test-failing"); err == nil {
+			t.Fatal("Expected the failure to create the client")
+		}
+	}
+
+	if factory.count() != 1 {
+		t.Errorf("Expected the failure to be cached, got %d attempts", factory.count())
+	}
+
+	factory.fail.Store(false)
+	retryFailedAfter = 0
+
+	if _, err := New("test-failing"); err != nil {
+		t.Errorf("Expected a retry once the failure expired: %v", err)
+	}
+
+	if factory.count() != 2 {
+		t.Errorf("Expected a second attempt, got %d", factory.count())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	registerTest(t, "test-b", &testFactory{})
+	registerTest(t, "test-a", &testFactory{})
+
+	registered := []string{}
+	for _, name := range Registered() {
+		if strings.HasPrefix(name, "test-") {
+			registered = append(registered, name)
+		}
+	}
+
+	if !reflect.DeepEqual(registered, []string{"test-a", "test-b"}) {
+		t.Errorf("Expected the registered test backends in order, got %v", registered)
+	}
+
+	for _, builtin := range []string{"kms", "localkey", "none", "vault"} {
+		if _, ok := getFactory(builtin); !ok {
+			t.Errorf("Expected the builtin %s backend to be registered", builtin)
+		}
+	}
+
+	panics := func(name string, factory Factory) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		Register(name, factory)
+		return false
+	}
+
+	if !panics("test-a", &testFactory{}) {
+		t.Error("Expected registering a name twice to panic")
+	}
+	if !panics("test-nil", nil) {
+		t.Error("Expected registering a nil factory to panic")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	factory := &testFactory{}
+	registerTest(t, "test-validate", factory)
+	defer setTestConfigs(t)
+
+	tests := []struct {
+		name    string
+		options []string
+		errText string
+	}{
+		{name: "unconfigured", options: nil},
+		{name: "configured", options: []string{"test-validate.enabled=true"}},
+		{name: "unknown backend", options: []string{"test-missing.enabled=true"}, errText: "unknown backend"},
+		{name: "misconfigured", options: []string{"test-validate.enabled=true", "test-validate.fail=true"}, errText: "misconfigured"},
+		{name: "failed validation", options: []string{"test-validate.enabled=true", "test-validate.client=invalid"}, errText: "failed validation"},
+	}
+
+	for _, test := range tests {
+		config := NewConfig()
+		for _, option := range test.options {
+			if err := config.Parse(option); err != nil {
+				t.Fatal(err)
+			}
+		}
+		factory.fail.Store(config.Section("test-validate").Get("fail") != "")
+		SetBackendConfigs(config)
+
+		err := Validate()
+		if test.errText == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if test.errText != "" && (err == nil || !strings.Contains(err.Error(), test.errText)) {
+			t.Errorf("%s: Expected an error containing %q, got %v", test.name, test.errText, err)
+		}
+	}
+}
+
+func TestNewIsLazy(t *testing.T) {
+	factory := &testFactory{}
+	registerTest(t, "test-lazy", factory)
+	defer setTestConfigs(t)
+
+	if _, err := New("test-unknown"); err == nil {
+		t.Error("Expected an unknown backend to fail")
+	}
+
+	setTestConfigs(t)
+	if IsConfigured("test-lazy") {
+		t.Error("Expected the backend to be unconfigured without a config section")
+	}
+	if _, err := New("test-lazy"); err == nil {
+		t.Error("Expected an unconfigured backend to fail")
+	}
+
+	config := NewConfig()
+	config.Set("test-lazy", "enabled", "true")
+	config.Set("test-lazy", "client", "closing")
+	SetBackendConfigs(config)
+
+	if !IsConfigured("test-lazy") || factory.count() != 0 {
+		t.Fatalf("Expected a configured backend without a client, %d created", factory.count())
+	}
+
+	first, err := New("test-lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New("test-lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second || factory.count() != 1 {
+		t.Errorf("Expected one shared client, %d created", factory.count())
+	}
+
+	// New configs drop and close the shared client
+	SetBackendConfigs(config)
+	if atomic.LoadInt32(&factory.closed) != 1 {
+		t.Error("Expected the shared client to be closed when the configs changed")
+	}
+
+	if third, _ := New("test-lazy"); third == first || factory.count() != 2 {
+		t.Errorf("Expected a new client for the new configs, %d created", factory.count())
+	}
+}
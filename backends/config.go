@@ -1,16 +1,83 @@
 package backends
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConfigSection holds the settings of a single backend
+type ConfigSection map[string]string
+
+// Get returns the value for key, or an empty string when unset
+func (cs ConfigSection) Get(key string) string {
+	return cs[key]
+}
+
+// Configs holds a config section per backend name
 type Configs struct {
-	VaultToken        string
-	VaultURL          string
-	EncryptionKeyPath string
+	sections map[string]ConfigSection
 }
 
 func NewConfig() *Configs {
-	return &Configs{}
+	return &Configs{
+		sections: map[string]ConfigSection{},
+	}
+}
+
+// Set stores a setting for a backend. Empty values are ignored so unset
+// flags do not mark a backend as configured.
+func (c *Configs) Set(backend, key, value string) {
+	if value == "" {
+		return
+	}
+
+	if _, ok := c.sections[backend]; !ok {
+		c.sections[backend] = ConfigSection{}
+	}
+
+	c.sections[backend][key] = value
+}
+
+// Parse sets a backend setting given as "backend.key=value"
+func (c *Configs) Parse(option string) error {
+	kv := strings.SplitN(option, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("Invalid backend config option, expected backend.key=value: %s", option)
+	}
+
+	name := strings.SplitN(kv[0], ".", 2)
+	if len(name) != 2 || name[0] == "" || name[1] == "" {
+		return fmt.Errorf("Invalid backend config option, expected backend.key=value: %s", option)
+	}
+
+	c.Set(name[0], name[1], kv[1])
+	return nil
+}
+
+// Section returns the settings of a backend, never nil
+func (c *Configs) Section(backend string) ConfigSection {
+	if section, ok := c.sections[backend]; ok {
+		return section
+	}
+
+	return ConfigSection{}
+}
+
+// Sections returns the sorted names of backends with settings
+func (c *Configs) Sections() []string {
+	names := []string{}
+	for name := range c.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
 }
 
+// SetBackendConfigs replaces the backend configs. Clients created with the
+// previous configs are closed.
 func SetBackendConfigs(config *Configs) error {
-	runtimeConfigs = config
+	replaceConfigs(config)
 	return nil
 }
@@ -0,0 +1,47 @@
+package backends
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigParse(t *testing.T) {
+	valid := map[string][3]string{
+		"vault.url=https://vault:8200":   {"vault", "url", "https://vault:8200"},
+		"localkey.keyPath=/etc/keys":     {"localkey", "keyPath", "/etc/keys"},
+		"kms.signingKey=alias/a=b":       {"kms", "signingKey", "alias/a=b"},
+		"pkcs11.module.path=/lib/p11.so": {"pkcs11", "module.path", "/lib/p11.so"},
+	}
+
+	for option, expected := range valid {
+		config := NewConfig()
+		if err := config.Parse(option); err != nil {
+			t.Errorf("%s: %v", option, err)
+			continue
+		}
+
+		if value := config.Section(expected[0]).Get(expected[1]); value != expected[2] {
+			t.Errorf("%s: Expected %s.%s to be %q, got %q", option, expected[0], expected[1], expected[2], value)
+		}
+	}
+
+	for _, option := range []string{"", "vault", "vault.url", "vault=x", ".url=x", "vault.=x"} {
+		if err := NewConfig().Parse(option); err == nil {
+			t.Errorf("Expected %q to be rejected", option)
+		}
+	}
+
+	config := NewConfig()
+	config.Set("vault", "url", "")
+	config.Parse("vault.token=")
+	config.Parse("localkey.keyPath=/etc/keys")
+	config.Parse("kms.region=us-east-1")
+
+	if sections := config.Sections(); !reflect.DeepEqual(sections, []string{"kms", "localkey"}) {
+		t.Errorf("Expected empty values to leave a backend unconfigured, got sections %v", sections)
+	}
+
+	if section := config.Section("missing"); section == nil || section.Get("url") != "" {
+		t.Errorf("Expected an empty section for a backend without settings, got %v", section)
+	}
+}
@@ -0,0 +1,331 @@
+package kms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/logutils"
+)
+
+var log = logutils.New("kms")
+
+const defaultSigningAlgorithm = "HMAC_SHA_256"
+
+// Client is the struct that implements the backend interface using
+// AWS KMS envelope encryption
+type Client struct {
+	endpoint         string
+	region           string
+	creds            *credentialCache
+	signingKey       string
+	signingAlgorithm string
+	httpClient       *http.Client
+}
+
+// Config holds the settings needed to talk to KMS
+type Config struct {
+	Region   string
+	Endpoint string
+	// Credentials are optional, see newCredentials for where they are
+	// looked up otherwise
+	Credentials
+	// STSEndpoint and MetadataEndpoint override the AWS STS and instance
+	// metadata endpoints credentials are fetched from
+	STSEndpoint      string
+	MetadataEndpoint string
+	// SigningKey is the KMS key used for Sign and VerifySignature, either
+	// an HMAC key or an asymmetric signing key
+	SigningKey string
+	// SigningAlgorithm is a KMS MacAlgorithm (HMAC_*) or SigningAlgorithm
+	SigningAlgorithm string
+}
+
+// internalSecret is the blob stored as cipher text, the data key wrapped by
+// KMS alongside the secret encrypted with it.
+type internalSecret struct {
+	EncryptedKey []byte `json:"encryptedKey"`
+	Secret       string `json:"secret"`
+}
+
+type kmsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// NewClient returns a Client ready to interact with KMS. An empty endpoint
+// uses the public regional endpoint.
+func NewClient(config Config) (*Client, error) {
+	if config.Region == "" {
+		return nil, errors.New("No KMS region configured")
+	}
+
+	// Every secret is signed, so a client without a signing key could not
+	// create any
+	if config.SigningKey == "" {
+		return nil, errors.New("No KMS signing key configured")
+	}
+
+	client := &Client{
+		endpoint:         strings.TrimSuffix(config.Endpoint, "/"),
+		region:           config.Region,
+		signingKey:       config.SigningKey,
+		signingAlgorithm: config.SigningAlgorithm,
+		httpClient:       &http.Client{Timeout: 30 * time.Second},
+	}
+	client.creds = newCredentials(config, client.httpClient)
+
+	if client.endpoint == "" {
+		client.endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", config.Region)
+	}
+
+	if client.signingAlgorithm == "" {
+		client.signingAlgorithm = defaultSigningAlgorithm
+	}
+
+	if _, err := client.digestHash(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// GetEncryptedText encrypts the clear text with a new KMS data key
+func (k *Client) GetEncryptedText(keyName, clearText string) (string, error) {
+	resp := struct {
+		CiphertextBlob []byte
+		Plaintext      []byte
+	}{}
+
+	err := k.call("GenerateDataKey", map[string]interface{}{
+		"KeyId":   keyName,
+		"KeySpec": "AES_256",
+	}, &resp)
+	if err != nil {
+		log.Error(err)
+		return "", fmt.Errorf("Issue generating data key with %s key", keyName)
+	}
+
+	secret, err := aesutils.GetEncryptedText(aesutils.NewAESKeyFromBytes(resp.Plaintext), clearText, "aes256-gcm")
+	if err != nil {
+		return "", err
+	}
+
+	blob, err := json.Marshal(&internalSecret{
+		EncryptedKey: resp.CiphertextBlob,
+		Secret:       secret,
+	})
+
+	return string(blob), err
+}
+
+// GetClearText unwraps the data key with KMS and decrypts the secret
+func (k *Client) GetClearText(keyName, cipherText string) (string, error) {
+	secret := &internalSecret{}
+	if err := json.Unmarshal([]byte(cipherText), secret); err != nil {
+		return "", err
+	}
+
+	resp := struct {
+		Plaintext []byte
+	}{}
+
+	err := k.call("Decrypt", map[string]interface{}{
+		"KeyId":          keyName,
+		"CiphertextBlob": secret.EncryptedKey,
+	}, &resp)
+	if err != nil {
+		log.Error(err)
+		return "", fmt.Errorf("Issue decrypting secret with %s key", keyName)
+	}
+
+	return aesutils.GetClearText(aesutils.NewAESKeyFromBytes(resp.Plaintext), secret.Secret)
+}
+
+// Sign signs a digest of a nonce and the clear text with the configured
+// signing key. Only the digest is sent to KMS, which also keeps secrets of
+// any size under its 4096 byte message limit.
+func (k *Client) Sign(keyName, clearText string) (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b64Nonce := base64.StdEncoding.EncodeToString(nonce)
+
+	digest, err := k.digest(b64Nonce, clearText)
+	if err != nil {
+		return "", err
+	}
+
+	var signature []byte
+	if k.isMac() {
+		resp := struct{ Mac []byte }{}
+		err = k.call("GenerateMac", map[string]interface{}{
+			"KeyId":        k.signingKey,
+			"Message":      digest,
+			"MacAlgorithm": k.signingAlgorithm,
+		}, &resp)
+		signature = resp.Mac
+	} else {
+		resp := struct{ Signature []byte }{}
+		err = k.call("Sign", map[string]interface{}{
+			"KeyId":            k.signingKey,
+			"Message":          digest,
+			"MessageType":      "DIGEST",
+			"SigningAlgorithm": k.signingAlgorithm,
+		}, &resp)
+		signature = resp.Signature
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if len(signature) == 0 {
+		return "", errors.New("Could not get a signature")
+	}
+
+	return b64Nonce + ":" + base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifySignature verifies the signature with the configured signing key
+func (k *Client) VerifySignature(keyName, signature, message string) (bool, error) {
+	sigSplit := strings.SplitN(signature, ":", 2)
+	if len(sigSplit) != 2 {
+		return false, errors.New("Invalid signature format")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(sigSplit[1])
+	if err != nil {
+		return false, err
+	}
+
+	digest, err := k.digest(sigSplit[0], message)
+	if err != nil {
+		return false, err
+	}
+
+	if k.isMac() {
+		resp := struct{ MacValid bool }{}
+		err = k.call("VerifyMac", map[string]interface{}{
+			"KeyId":        k.signingKey,
+			"Message":      digest,
+			"Mac":          sig,
+			"MacAlgorithm": k.signingAlgorithm,
+		}, &resp)
+		return err == nil && resp.MacValid, err
+	}
+
+	resp := struct{ SignatureValid bool }{}
+	err = k.call("Verify", map[string]interface{}{
+		"KeyId":            k.signingKey,
+		"Message":          digest,
+		"MessageType":      "DIGEST",
+		"Signature":        sig,
+		"SigningAlgorithm": k.signingAlgorithm,
+	}, &resp)
+	if kerr, ok := err.(*kmsError); ok && strings.HasSuffix(kerr.Type, "KMSInvalidSignatureException") {
+		return false, nil
+	}
+
+	return err == nil && resp.SignatureValid, err
+}
+
+// Delete No op nothing stored
+func (k *Client) Delete(keyName, cipherText string) error {
+	return nil
+}
+
+func (k *Client) isMac() bool {
+	return strings.HasPrefix(k.signingAlgorithm, "HMAC_")
+}
+
+// digestHash returns the hash the signed digest is made with. KMS signing
+// algorithms need a digest of their own hash, MACs are taken over SHA-256.
+func (k *Client) digestHash() (crypto.Hash, error) {
+	switch {
+	case k.isMac(), strings.HasSuffix(k.signingAlgorithm, "_SHA_256"):
+		return crypto.SHA256, nil
+	case strings.HasSuffix(k.signingAlgorithm, "_SHA_384"):
+		return crypto.SHA384, nil
+	case strings.HasSuffix(k.signingAlgorithm, "_SHA_512"):
+		return crypto.SHA512, nil
+	}
+
+	return 0, fmt.Errorf("Unsupported KMS signing algorithm: %s", k.signingAlgorithm)
+}
+
+func (k *Client) digest(nonce, message string) ([]byte, error) {
+	hash, err := k.digestHash()
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write([]byte(nonce + ":" + message))
+	return h.Sum(nil), nil
+}
+
+// call invokes a KMS API operation. []byte fields are sent and received
+// base64 encoded as the KMS JSON protocol expects.
+func (k *Client) call(operation string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", k.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+operation)
+
+	creds, err := k.creds.get()
+	if err != nil {
+		return err
+	}
+
+	signRequest(req, body, creds, k.region, time.Now())
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		kerr := &kmsError{}
+		if err := json.Unmarshal(respBody, kerr); err != nil || kerr.Type == "" {
+			return fmt.Errorf("KMS %s failed with status %d", operation, resp.StatusCode)
+		}
+		return kerr
+	}
+
+	return json.Unmarshal(respBody, output)
+}
+
+func (e *kmsError) Error() string {
+	errType := e.Type
+	if i := strings.LastIndex(errType, "#"); i >= 0 {
+		errType = errType[i+1:]
+	}
+
+	return fmt.Sprintf("KMS %s: %s", errType, e.Message)
+}
@@ -0,0 +1,201 @@
+package kms
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const secretText = "my secret to keep"
+
+var digestSizes = map[string]int{
+	"ECDSA_SHA_256":      32,
+	"ECDSA_SHA_384":      48,
+	"RSASSA_PSS_SHA_512": 64,
+}
+
+// fakeKMS implements just enough of the KMS JSON protocol to exercise the
+// client. Data keys are "wrapped" by prefixing them with the key id.
+func fakeKMS(t *testing.T) *httptest.Server {
+	macKey := []byte("fake mac key")
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") {
+			t.Errorf("Request was not signed: %s", r.Header.Get("Authorization"))
+		}
+
+		// t.Fatal must not be called outside the test goroutine
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		decodeBytes := func(field string) []byte {
+			var b []byte
+			raw, _ := json.Marshal(input[field])
+			json.Unmarshal(raw, &b)
+			return b
+		}
+
+		mac := func(message []byte) []byte {
+			m := hmac.New(sha256.New, macKey)
+			m.Write(message)
+			return m.Sum(nil)
+		}
+
+		// Like KMS, refuse messages over 4096 bytes and digests that do
+		// not match the signing algorithm
+		message := decodeBytes("Message")
+		if len(message) > 4096 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ValidationException","message":"message too long"}`))
+			return
+		}
+		if input["MessageType"] == "DIGEST" && len(message) != digestSizes[input["SigningAlgorithm"].(string)] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ValidationException","message":"digest length does not match"}`))
+			return
+		}
+
+		var output interface{}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			plainText := []byte("0123456789abcdef0123456789abcdef")
+			output = map[string][]byte{
+				"Plaintext":      plainText,
+				"CiphertextBlob": append([]byte(input["KeyId"].(string)+":"), plainText...),
+			}
+		case "TrentService.Decrypt":
+			blob := decodeBytes("CiphertextBlob")
+			prefix := input["KeyId"].(string) + ":"
+			if !strings.HasPrefix(string(blob), prefix) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"IncorrectKeyException","message":"wrong key"}`))
+				return
+			}
+			output = map[string][]byte{"Plaintext": blob[len(prefix):]}
+		case "TrentService.GenerateMac":
+			output = map[string][]byte{"Mac": mac(decodeBytes("Message"))}
+		case "TrentService.VerifyMac":
+			output = map[string]bool{"MacValid": hmac.Equal(mac(decodeBytes("Message")), decodeBytes("Mac"))}
+		case "TrentService.Sign":
+			output = map[string][]byte{"Signature": mac(message)}
+		case "TrentService.Verify":
+			if !hmac.Equal(mac(message), decodeBytes("Signature")) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"KMSInvalidSignatureException","message":"invalid"}`))
+				return
+			}
+			output = map[string]bool{"SignatureValid": true}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(output)
+	}))
+}
+
+func TestKMSClient(t *testing.T) {
+	server := fakeKMS(t)
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Region:   "us-east-1",
+		Endpoint: server.URL,
+		Credentials: Credentials{
+			AccessKeyID:     "test",
+			SecretAccessKey: "test",
+		},
+		SigningKey: "alias/signing",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encData, err := client.GetEncryptedText("alias/testing", secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(encData, "0123456789abcdef") {
+		t.Error("Plain text data key stored in cipher text")
+	}
+
+	data, err := client.GetClearText("alias/testing", encData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data != secretText {
+		t.Errorf("Secret data decrypted to '%s' and we expected '%s'", data, secretText)
+	}
+
+	if _, err := client.GetClearText("alias/other", encData); err == nil {
+		t.Error("Expected decrypting with the wrong key to fail")
+	}
+
+	signature, err := client.Sign("alias/testing", secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if match, err := client.VerifySignature("alias/testing", signature, secretText); !match || err != nil {
+		t.Errorf("Signature did not verify: %v", err)
+	}
+
+	if match, _ := client.VerifySignature("alias/testing", signature, "tampered"); match {
+		t.Error("Signature verified against the wrong message")
+	}
+}
+
+func TestKMSSignDigest(t *testing.T) {
+	server := fakeKMS(t)
+	defer server.Close()
+
+	// Larger than the 4096 bytes KMS accepts as a message
+	largeSecret := strings.Repeat("s", 8192)
+
+	for _, algorithm := range []string{"", "HMAC_SHA_256", "ECDSA_SHA_256", "ECDSA_SHA_384", "RSASSA_PSS_SHA_512"} {
+		client, err := NewClient(Config{
+			Region:           "us-east-1",
+			Endpoint:         server.URL,
+			Credentials:      Credentials{AccessKeyID: "test", SecretAccessKey: "test"},
+			SigningKey:       "alias/signing",
+			SigningAlgorithm: algorithm,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signature, err := client.Sign("alias/testing", largeSecret)
+		if err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+			continue
+		}
+
+		if match, err := client.VerifySignature("alias/testing", signature, largeSecret); !match || err != nil {
+			t.Errorf("%s: Signature did not verify: %v", algorithm, err)
+		}
+
+		if match, _ := client.VerifySignature("alias/testing", signature, largeSecret+"x"); match {
+			t.Errorf("%s: Signature verified against the wrong message", algorithm)
+		}
+	}
+
+	invalid := map[string]Config{
+		"no signing key":    {Region: "us-east-1"},
+		"unknown algorithm": {Region: "us-east-1", SigningKey: "alias/signing", SigningAlgorithm: "SM2DSA"},
+	}
+	for name, config := range invalid {
+		if _, err := NewClient(config); err == nil {
+			t.Errorf("%s: Expected the config to be rejected", name)
+		}
+	}
+}
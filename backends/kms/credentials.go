@@ -0,0 +1,272 @@
+package kms
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMetadataEndpoint = "http://169.254.169.254"
+	metadataTokenTTL        = "21600"
+	metadataTimeout         = 2 * time.Second
+
+	// refreshWindow is how long before they expire temporary credentials
+	// are replaced
+	refreshWindow = 5 * time.Minute
+)
+
+// credentialSource fetches credentials, a zero expiry means they never expire
+type credentialSource interface {
+	retrieve() (Credentials, time.Time, error)
+}
+
+// credentialCache hands out credentials from its source, fetching new ones
+// shortly before the current ones expire
+type credentialCache struct {
+	source credentialSource
+
+	lock    sync.Mutex
+	creds   Credentials
+	expires time.Time
+	fetched bool
+}
+
+// newCredentials picks the credentials to sign requests with, in the order
+// of the AWS SDKs: credentials given in the config, the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables, a web identity token named by
+// AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, and finally the instance
+// metadata service. Nothing is fetched until the first request.
+func newCredentials(config Config, httpClient *http.Client) *credentialCache {
+	var source credentialSource
+
+	switch {
+	case config.AccessKeyID != "" && config.SecretAccessKey != "":
+		source = staticSource{creds: config.Credentials}
+	case os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "":
+		source = staticSource{creds: Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}}
+	case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "":
+		endpoint := config.STSEndpoint
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", config.Region)
+		}
+
+		sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+		if sessionName == "" {
+			sessionName = "secrets-api"
+		}
+
+		source = &webIdentitySource{
+			endpoint:    strings.TrimSuffix(endpoint, "/"),
+			tokenFile:   os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+			roleARN:     os.Getenv("AWS_ROLE_ARN"),
+			sessionName: sessionName,
+			httpClient:  httpClient,
+		}
+	default:
+		endpoint := config.MetadataEndpoint
+		if endpoint == "" {
+			endpoint = defaultMetadataEndpoint
+		}
+
+		// The metadata service answers quickly or not at all
+		source = &metadataSource{
+			endpoint:   strings.TrimSuffix(endpoint, "/"),
+			httpClient: &http.Client{Timeout: metadataTimeout},
+		}
+	}
+
+	return &credentialCache{source: source}
+}
+
+// get returns the current credentials, fetching them when they are missing
+// or about to expire
+func (c *credentialCache) get() (Credentials, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.fetched && (c.expires.IsZero() || time.Until(c.expires) > refreshWindow) {
+		return c.creds, nil
+	}
+
+	creds, expires, err := c.source.retrieve()
+	if err != nil {
+		// Keep using credentials that have not expired yet
+		if c.fetched && time.Now().Before(c.expires) {
+			log.Warnf("Could not refresh AWS credentials: %v", err)
+			return c.creds, nil
+		}
+		return Credentials{}, err
+	}
+
+	c.creds, c.expires, c.fetched = creds, expires, true
+	return creds, nil
+}
+
+type staticSource struct {
+	creds Credentials
+}
+
+func (s staticSource) retrieve() (Credentials, time.Time, error) {
+	return s.creds, time.Time{}, nil
+}
+
+// webIdentitySource exchanges a web identity token, such as a Kubernetes
+// service account token, for role credentials with STS. The token file is
+// read on every exchange since it is rotated.
+type webIdentitySource struct {
+	endpoint    string
+	tokenFile   string
+	roleARN     string
+	sessionName string
+	httpClient  *http.Client
+}
+
+type assumeRoleWithWebIdentityResponse struct {
+	Credentials struct {
+		AccessKeyID     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+func (s *webIdentitySource) retrieve() (Credentials, time.Time, error) {
+	token, err := ioutil.ReadFile(s.tokenFile)
+	if err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {s.roleARN},
+		"RoleSessionName":  {s.sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	resp, err := s.httpClient.PostForm(s.endpoint+"/", form)
+	if err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, time.Time{}, fmt.Errorf("STS AssumeRoleWithWebIdentity failed with status %d", resp.StatusCode)
+	}
+
+	result := &assumeRoleWithWebIdentityResponse{}
+	if err := xml.Unmarshal(body, result); err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+
+	creds := result.Credentials
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, time.Time{}, errors.New("No credentials in the STS response")
+	}
+
+	return Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}, creds.Expiration, nil
+}
+
+// metadataSource reads the credentials of the instance's IAM role from the
+// EC2 instance metadata service, using IMDSv2 session tokens
+type metadataSource struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+type metadataCredentials struct {
+	Code            string
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func (s *metadataSource) retrieve() (Credentials, time.Time, error) {
+	req, err := http.NewRequest("PUT", s.endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", metadataTokenTTL)
+
+	token, err := s.read(req)
+	if err != nil {
+		return Credentials{}, time.Time{}, fmt.Errorf("No AWS credentials configured and the instance metadata service is unavailable: %v", err)
+	}
+
+	role, err := s.get("/latest/meta-data/iam/security-credentials/", string(token))
+	if err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+
+	roleName := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	if roleName == "" {
+		return Credentials{}, time.Time{}, errors.New("No IAM role attached to the instance")
+	}
+
+	data, err := s.get("/latest/meta-data/iam/security-credentials/"+roleName, string(token))
+	if err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+
+	creds := &metadataCredentials{}
+	if err := json.Unmarshal(data, creds); err != nil {
+		return Credentials{}, time.Time{}, err
+	}
+
+	if creds.Code != "Success" {
+		return Credentials{}, time.Time{}, fmt.Errorf("Instance metadata credentials unavailable: %s", creds.Code)
+	}
+
+	return Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.Token,
+	}, creds.Expiration, nil
+}
+
+func (s *metadataSource) get(path, token string) ([]byte, error) {
+	req, err := http.NewRequest("GET", s.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+
+	return s.read(req)
+}
+
+func (s *metadataSource) read(req *http.Request) ([]byte, error) {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s failed with status %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
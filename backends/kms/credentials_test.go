@@ -0,0 +1,104 @@
+package kms
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestCredentialChain(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN"} {
+		t.Setenv(name, "")
+	}
+
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/":
+			r.ParseForm()
+			if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "service-account-token" ||
+				r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/secrets" {
+				t.Errorf("Unexpected STS request %v", r.Form)
+			}
+			fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+				<AccessKeyId>web-id</AccessKeyId><SecretAccessKey>web-secret</SecretAccessKey>
+				<SessionToken>web-session</SessionToken><Expiration>%s</Expiration>
+				</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`, expiration)
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte("imds-token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("instance-role"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/instance-role":
+			fmt.Fprintf(w, `{"Code": "Success", "AccessKeyId": "imds-id", "SecretAccessKey": "imds-secret", "Token": "imds-session", "Expiration": %q}`, expiration)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := Config{Region: "us-east-1", STSEndpoint: server.URL, MetadataEndpoint: server.URL}
+
+	get := func() Credentials {
+		creds, err := newCredentials(config, http.DefaultClient).get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return creds
+	}
+
+	// Without anything else set the instance metadata service is used
+	if creds := get(); creds.AccessKeyID != "imds-id" || creds.SessionToken != "imds-session" {
+		t.Errorf("Expected instance metadata credentials, got %+v", creds)
+	}
+
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := path.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("service-account-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/secrets")
+	if creds := get(); creds.AccessKeyID != "web-id" || creds.SessionToken != "web-session" {
+		t.Errorf("Expected web identity credentials, got %+v", creds)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	if creds := get(); creds.AccessKeyID != "env-id" {
+		t.Errorf("Expected environment credentials, got %+v", creds)
+	}
+
+	// Temporary credentials are reused until they are about to expire
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+
+	calls = 0
+	cache := newCredentials(config, http.DefaultClient)
+	for i := 0; i < 3; i++ {
+		if creds, err := cache.get(); err != nil || creds.AccessKeyID != "web-id" {
+			t.Fatalf("Expected web identity credentials, got %+v: %v", creds, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the credentials to be fetched once, got %d calls", calls)
+	}
+}
@@ -1,17 +1,33 @@
 package localkey
 
 import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
-	"path"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/logutils"
 )
 
+const (
+	envelopeSignaturePrefix = "dek:"
+	envelopeSignatureInfo   = "secrets-api localkey envelope signature"
+)
+
+var log = logutils.New("localkey")
+
 // Client implements the backend client interface
 type Client struct {
 	encryptionKeyPath string
+	store             *keyStore
 }
 
 // support both IV and Nonce for non-breaking
@@ -22,63 +38,301 @@ type internalSecret struct {
 	CipherText []byte
 }
 
-// NewLocalKey initializes a new local key
+// envelope is the blob localkey stores. The secret is encrypted with a random
+// data key, and only the data key is encrypted with the master key file.
+type envelope struct {
+	EncryptedKey string `json:"encryptedKey,omitempty"`
+	Secret       string `json:"secret,omitempty"`
+}
+
+// NewLocalKey initializes a new local key client that checks the key path
+// for changes every DefaultReloadInterval
 func NewLocalKey(keyPath string) (*Client, error) {
+	return NewLocalKeyWithReload(keyPath, DefaultReloadInterval)
+}
+
+// NewLocalKeyWithReload initializes a new local key client. Keys are loaded
+// once and the key path is checked for changes every interval, an interval of
+// 0 only reloads when a key is not found, at most once a second.
+func NewLocalKeyWithReload(keyPath string, interval time.Duration) (*Client, error) {
 	err := errors.New("No encryption key path configured. Must be a directory")
 
 	if keyPath != "" {
 		if isDir, err := testIsDir(keyPath); isDir && err == nil {
-			return &Client{encryptionKeyPath: keyPath}, nil
+			client := &Client{
+				encryptionKeyPath: keyPath,
+				store:             newKeyStore(keyPath),
+			}
+
+			if err := client.store.reload(); err != nil {
+				return nil, err
+			}
+
+			if interval > 0 {
+				go client.store.watch(interval)
+			}
+
+			return client, nil
 		}
 	}
 
 	return &Client{}, err
 }
 
-func (l *Client) loadEncryptionKeyFromPath(keyName string) (aesutils.AESKey, error) {
-	keyFile := path.Join(l.encryptionKeyPath, keyName)
+// Reload picks up new, rotated and removed keys without waiting for the
+// next check
+func (l *Client) Reload() error {
+	return l.store.reload()
+}
+
+// Close stops watching the key path and wipes the cached keys
+func (l *Client) Close() error {
+	if l.store != nil {
+		l.store.close()
+	}
+	return nil
+}
+
+// Validate reads every key under the key path, plain files as well as the
+// numbered files of versioned keys, and logs each one that cannot be used
+func (l *Client) Validate() error {
+	found, err := l.store.scan()
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	for keyName, versions := range found {
+		for version := range versions {
+			key, err := readKey(l.store.keyFile(keyName, version))
+			if err != nil {
+				log.Errorf("Invalid localkey key: %v", err)
+				invalid++
+				continue
+			}
+			zero(key)
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid key(s) in %s", invalid, l.encryptionKeyPath)
+	}
 
-	return aesutils.NewAESKeyFromFile(keyFile)
+	return nil
 }
 
-// GetEncryptedText localkey Client just returns the clearText
+// GetEncryptedText encrypts with a new data key wrapped by the newest
+// version of the master key
 func (l *Client) GetEncryptedText(keyName, clearText string) (string, error) {
-	key, err := l.loadEncryptionKeyFromPath(keyName)
+	dataKey, err := aesutils.NewRandomAESKey(32)
+	if err != nil {
+		return "", err
+	}
+
+	dataKeyBytes, err := dataKey.Key()
+	if err != nil {
+		return "", err
+	}
+
+	secret := &envelope{}
+
+	secret.EncryptedKey, err = l.wrapDataKey(keyName, dataKeyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	secret.Secret, err = aesutils.GetEncryptedText(dataKey, clearText, "aes256-gcm")
 	if err != nil {
 		return "", err
 	}
 
-	return aesutils.GetEncryptedText(key, clearText, "aes256-gcm")
+	jsonSecret, err := json.Marshal(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonSecret), nil
 }
 
-// GetClearText localkey Client
+// GetClearText unwraps the data key with the master key version recorded in
+// the blob. Blobs without a data key were encrypted with the master key directly.
 func (l *Client) GetClearText(keyName, secretBlob string) (string, error) {
-	key, err := l.loadEncryptionKeyFromPath(keyName)
+	secret := &envelope{}
+
+	err := json.Unmarshal([]byte(secretBlob), secret)
+	if err != nil {
+		return "", err
+	}
+
+	if secret.EncryptedKey == "" {
+		return l.decryptWithMasterKey(keyName, secretBlob)
+	}
+
+	dataKey, err := l.decryptWithMasterKey(keyName, secret.EncryptedKey)
 	if err != nil {
 		return "", err
 	}
 
-	return aesutils.GetClearText(key, secretBlob)
+	return aesutils.GetClearText(aesutils.NewAESKeyFromBytes([]byte(dataKey)), secret.Secret)
 }
 
-// Sign implements the interface
-func (l *Client) Sign(keyName, clearText string) (string, error) {
-	key, err := l.loadEncryptionKeyFromPath(keyName)
+func (l *Client) decryptWithMasterKey(keyName, secretBlob string) (string, error) {
+	version, err := aesutils.GetKeyVersion(secretBlob)
 	if err != nil {
 		return "", err
 	}
 
-	return aesutils.Sign(key, clearText)
+	var clearText string
+	err = l.store.withKey(keyName, version, func(key aesutils.AESKey, _ int) error {
+		var err error
+		clearText, err = aesutils.GetClearText(key, secretBlob)
+		return err
+	})
+
+	return clearText, err
+}
+
+// wrapDataKey encrypts a data key with the newest version of the master key
+func (l *Client) wrapDataKey(keyName string, dataKey []byte) (string, error) {
+	var encryptedKey string
+	err := l.store.withLatestKey(keyName, func(masterKey aesutils.AESKey, _ int) error {
+		var err error
+		encryptedKey, err = aesutils.GetEncryptedText(masterKey, string(dataKey), "aes256-gcm")
+		return err
+	})
+
+	return encryptedKey, err
 }
 
-// VerifySignature implements the interface.
+// Sign is not supported, localkey secrets are signed with SignEnvelope so
+// that the master key never touches the clear text
+func (l *Client) Sign(keyName, clearText string) (string, error) {
+	return "", errors.New("localkey signs the envelope, not the clear text")
+}
+
+// VerifySignature checks signatures made over the clear text with the master
+// key, before secrets were signed with their data key. Signatures made with a
+// versioned key are prefixed with the version: "<version>:<signature>"
 func (l *Client) VerifySignature(keyName, signature, message string) (bool, error) {
-	key, err := l.loadEncryptionKeyFromPath(keyName)
+	version, signature, err := splitVersionedSignature(signature)
+	if err != nil {
+		return false, err
+	}
+
+	var match bool
+	err = l.store.withKey(keyName, version, func(key aesutils.AESKey, _ int) error {
+		var err error
+		match, err = aesutils.VerifySignature(key, signature, message)
+		return err
+	})
+
+	return match, err
+}
+
+// SignEnvelope signs the sealed secret of an envelope with a key derived from
+// its data key: "dek:<signature>". The wrapped data key is not covered, so
+// the signature stays valid when RewrapDataKey wraps it again.
+func (l *Client) SignEnvelope(keyName, secretBlob string) (string, error) {
+	secret, dataKey, err := l.openEnvelope(keyName, secretBlob)
+	if err != nil {
+		return "", err
+	}
+	defer zero(dataKey)
+
+	mac, err := envelopeMAC(dataKey, keyName, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return envelopeSignaturePrefix + base64.StdEncoding.EncodeToString(mac), nil
+}
+
+// EnvelopeSigned reports whether a signature was made by SignEnvelope
+func (l *Client) EnvelopeSigned(signature string) bool {
+	return strings.HasPrefix(signature, envelopeSignaturePrefix)
+}
+
+// VerifyEnvelope checks a signature made by SignEnvelope
+func (l *Client) VerifyEnvelope(keyName, signature, secretBlob string) (bool, error) {
+	if !l.EnvelopeSigned(signature) {
+		return false, errors.New("Not an envelope signature")
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(signature, envelopeSignaturePrefix))
+	if err != nil {
+		return false, err
+	}
+
+	secret, dataKey, err := l.openEnvelope(keyName, secretBlob)
 	if err != nil {
 		return false, err
 	}
+	defer zero(dataKey)
+
+	mac, err := envelopeMAC(dataKey, keyName, secret)
+	if err != nil {
+		return false, err
+	}
+
+	return hmac.Equal(mac, expected), nil
+}
+
+// RewrapDataKey wraps the data key of a secret with the newest version of the
+// master key. The secret itself is not decrypted.
+func (l *Client) RewrapDataKey(keyName, secretBlob string) (string, error) {
+	secret, dataKey, err := l.openEnvelope(keyName, secretBlob)
+	if err != nil {
+		return "", err
+	}
+	defer zero(dataKey)
+
+	secret.EncryptedKey, err = l.wrapDataKey(keyName, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	jsonSecret, err := json.Marshal(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonSecret), nil
+}
+
+// openEnvelope parses a blob and unwraps its data key
+func (l *Client) openEnvelope(keyName, secretBlob string) (*envelope, []byte, error) {
+	secret := &envelope{}
+	if err := json.Unmarshal([]byte(secretBlob), secret); err != nil {
+		return nil, nil, err
+	}
+
+	if secret.EncryptedKey == "" {
+		return nil, nil, errors.New("Secret was not sealed with a data key")
+	}
 
-	return aesutils.VerifySignature(key, signature, message)
+	dataKey, err := l.decryptWithMasterKey(keyName, secret.EncryptedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return secret, []byte(dataKey), nil
+}
+
+// envelopeMAC is an HMAC-SHA256 of the key name and sealed secret, keyed with
+// a key derived from the data key rather than the data key itself
+func envelopeMAC(dataKey []byte, keyName string, secret *envelope) ([]byte, error) {
+	macKey, err := hkdf.Key(sha256.New, dataKey, nil, envelopeSignatureInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(macKey)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write([]byte(keyName))
+	mac.Write([]byte{0})
+	mac.Write([]byte(secret.Secret))
+
+	return mac.Sum(nil), nil
 }
 
 // Delete No op nothing stored
@@ -91,7 +345,7 @@ func testIsDir(keyPath string) (bool, error) {
 
 	file, err := os.Open(keyPath)
 	if err != nil {
-		logrus.Error(err)
+		log.Error(err)
 		return result, err
 	}
 	defer file.Close()
@@ -103,3 +357,19 @@ func testIsDir(keyPath string) (bool, error) {
 
 	return fs.IsDir(), nil
 }
+
+// Base64 signatures never contain ':' so unversioned signatures are
+// left as is.
+func splitVersionedSignature(signature string) (int, string, error) {
+	sigSplit := strings.SplitN(signature, ":", 2)
+	if len(sigSplit) != 2 {
+		return 0, signature, nil
+	}
+
+	version, err := strconv.Atoi(sigSplit[0])
+	if err != nil || version < 1 {
+		return 0, "", errors.New("Invalid signature key version")
+	}
+
+	return version, sigSplit[1], nil
+}
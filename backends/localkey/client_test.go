@@ -1,9 +1,17 @@
 package localkey
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/testutils"
 )
 
 const secretText = "my secret to keep"
@@ -46,3 +54,333 @@ func TestLocalKeyClient(t *testing.T) {
 	}
 
 }
+
+func TestLocalKeyRotation(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "localkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	if err := os.Mkdir(path.Join(keyPath, "testing"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	writeKeyVersion(t, keyPath, "testing", 1)
+
+	client, err := NewLocalKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldCipherText, err := client.GetEncryptedText("testing", secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldSignature, err := client.SignEnvelope("testing", oldCipherText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKeyVersion(t, keyPath, "testing", 2)
+	if err := client.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if version := dataKeyVersion(t, oldCipherText); version != 1 {
+		t.Errorf("Expected key version 1, got %d", version)
+	}
+
+	newCipherText, err := client.GetEncryptedText("testing", secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version := dataKeyVersion(t, newCipherText); version != 2 {
+		t.Errorf("Expected key version 2, got %d", version)
+	}
+
+	for _, cipherText := range []string{oldCipherText, newCipherText} {
+		data, err := client.GetClearText("testing", cipherText)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if data != secretText {
+			t.Errorf("Secret data decrypted to '%s' and we expected '%s'", data, secretText)
+		}
+	}
+
+	match, err := client.VerifyEnvelope("testing", oldSignature, oldCipherText)
+	if err != nil || !match {
+		t.Errorf("Signature from key version 1 did not verify: %v", err)
+	}
+
+	// Only the data key is wrapped again, the signature stays valid
+	rewrapped, err := client.RewrapDataKey("testing", oldCipherText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version := dataKeyVersion(t, rewrapped); version != 2 {
+		t.Errorf("Expected the rewrapped data key to use version 2, got %d", version)
+	}
+
+	oldSecret, newSecret := &envelope{}, &envelope{}
+	json.Unmarshal([]byte(oldCipherText), oldSecret)
+	json.Unmarshal([]byte(rewrapped), newSecret)
+	if oldSecret.Secret != newSecret.Secret {
+		t.Error("Expected rewrapping the data key to leave the secret as is")
+	}
+
+	match, err = client.VerifyEnvelope("testing", oldSignature, rewrapped)
+	if err != nil || !match {
+		t.Errorf("Signature did not verify after rewrapping the data key: %v", err)
+	}
+
+	if data, err := client.GetClearText("testing", rewrapped); err != nil || data != secretText {
+		t.Errorf("Secret data decrypted to '%s' and we expected '%s': %v", data, secretText, err)
+	}
+}
+
+func TestLocalKeyEnvelope(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "localkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	masterKey, err := aesutils.NewRandomAESKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, _ := masterKey.Key()
+	keyData, _ := aesutils.FormatKeyFile(keyBytes, aesutils.KeyFormatRaw)
+	if err := ioutil.WriteFile(path.Join(keyPath, "testing"), keyData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewLocalKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encData, err := client.GetEncryptedText("testing", secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &envelope{}
+	if err := json.Unmarshal([]byte(encData), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := aesutils.GetClearText(masterKey, secret.Secret); err == nil {
+		t.Error("Secret was encrypted with the master key instead of a data key")
+	}
+
+	data, err := client.GetClearText("testing", encData)
+	if err != nil || data != secretText {
+		t.Errorf("Secret data decrypted to '%s' and we expected '%s': %v", data, secretText, err)
+	}
+
+	// Blobs from before envelope encryption are sealed with the master key
+	legacyData, err := aesutils.GetEncryptedText(masterKey, secretText, "aes256-gcm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = client.GetClearText("testing", legacyData)
+	if err != nil || data != secretText {
+		t.Errorf("Legacy secret data decrypted to '%s' and we expected '%s': %v", data, secretText, err)
+	}
+
+	// Signatures cover the sealed secret, keyed by the data key
+	signature, err := client.SignEnvelope("testing", encData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !client.EnvelopeSigned(signature) {
+		t.Errorf("Expected %s to be an envelope signature", signature)
+	}
+
+	if match, err := client.VerifyEnvelope("testing", signature, encData); err != nil || !match {
+		t.Errorf("Envelope signature did not verify: %v", err)
+	}
+
+	otherData, _ := client.GetEncryptedText("testing", secretText)
+	if match, _ := client.VerifyEnvelope("testing", signature, otherData); match {
+		t.Error("Expected the signature not to verify another secret")
+	}
+
+	if _, err := client.Sign("testing", secretText); err == nil {
+		t.Error("Expected signing the clear text with the master key to fail")
+	}
+
+	// Signatures from before envelope signing still verify
+	legacySignature, err := aesutils.Sign(masterKey, secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.EnvelopeSigned(legacySignature) {
+		t.Error("Expected a clear text signature not to be an envelope signature")
+	}
+
+	if match, err := client.VerifySignature("testing", legacySignature, secretText); err != nil || !match {
+		t.Errorf("Legacy signature did not verify: %v", err)
+	}
+}
+
+func TestLocalKeyWatch(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "localkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	if err := os.Mkdir(path.Join(keyPath, "testing"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	writeKeyVersion(t, keyPath, "testing", 1)
+
+	client, err := NewLocalKeyWithReload(keyPath, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	writeKeyVersion(t, keyPath, "testing", 2)
+	testutils.WaitFor(t, "key version 2 to load", func() bool {
+		return latestVersion(client, "testing") == 2
+	})
+
+	if err := os.Remove(path.Join(keyPath, "testing", "2")); err != nil {
+		t.Fatal(err)
+	}
+	testutils.WaitFor(t, "key version 2 to be evicted", func() bool {
+		return latestVersion(client, "testing") == 1
+	})
+
+	if _, err := client.GetEncryptedText("missing", secretText); err == nil {
+		t.Error("Expected encrypting with a missing key to fail")
+	}
+
+	client.Close()
+	if _, err := client.GetEncryptedText("testing", secretText); err == nil {
+		t.Error("Expected a closed client to have no keys")
+	}
+}
+
+func TestLocalKeyMissReload(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "localkey-miss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	if err := os.Mkdir(path.Join(keyPath, "testing"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeKeyVersion(t, keyPath, "testing", 1)
+
+	// Only lookups that miss reload
+	client, err := NewLocalKeyWithReload(keyPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := os.Mkdir(path.Join(keyPath, "added"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeKeyVersion(t, keyPath, "added", 1)
+
+	// The keys were just loaded, so the miss does not rescan the key path
+	if _, err := client.GetEncryptedText("added", secretText); err == nil {
+		t.Error("Expected a miss right after a reload not to reload")
+	}
+
+	client.store.reloadLock.Lock()
+	client.store.lastReload = time.Now().Add(-missReloadInterval)
+	client.store.reloadLock.Unlock()
+
+	if _, err := client.GetEncryptedText("added", secretText); err != nil {
+		t.Errorf("Expected a miss to reload once the interval passed: %v", err)
+	}
+}
+
+func latestVersion(client *Client, keyName string) int {
+	latest := 0
+	client.store.withLatestKey(keyName, func(key aesutils.AESKey, version int) error {
+		latest = version
+		return nil
+	})
+	return latest
+}
+
+func dataKeyVersion(t *testing.T, cipherText string) int {
+	secret := &envelope{}
+	if err := json.Unmarshal([]byte(cipherText), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := aesutils.GetKeyVersion(secret.EncryptedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return version
+}
+
+func writeKeyVersion(t *testing.T, keyPath, keyName string, version int) {
+	key, err := aesutils.NewRandomAESKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, _ := key.Key()
+	keyData, _ := aesutils.FormatKeyFile(keyBytes, aesutils.KeyFormatRaw)
+
+	keyFile := path.Join(keyPath, keyName, strconv.Itoa(version))
+	if err := ioutil.WriteFile(keyFile, keyData, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalKeyValidate(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "localkey-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	if err := ioutil.WriteFile(path.Join(keyPath, "hexkey"), []byte("# format=hex\n"+strings.Repeat("ab", 32)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path.Join(keyPath, "rotated"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeKeyVersion(t, keyPath, "rotated", 1)
+
+	client, err := NewLocalKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Validate(); err != nil {
+		t.Fatalf("Expected valid keys, got: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(keyPath, "rotated", "2"), []byte("too short\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Validate(); err == nil {
+		t.Fatal("Expected an invalid key version to fail validation")
+	}
+}
@@ -0,0 +1,346 @@
+package localkey
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/secrets-api/pkg/aesutils"
+)
+
+// DefaultReloadInterval is how often the key path is checked for new,
+// rotated or removed keys
+const DefaultReloadInterval = 10 * time.Second
+
+// missReloadInterval limits the reloads of lookups for keys that are not
+// cached, so asking for unknown key names can not keep rescanning the key path
+const missReloadInterval = time.Second
+
+// keyStore caches the keys under a key path in locked memory. Keys are read
+// once and only read again when their file changes.
+type keyStore struct {
+	keyPath string
+
+	// reloadLock serializes reloads and guards lastReload, lock guards keys
+	reloadLock sync.Mutex
+	lastReload time.Time
+	lock       sync.RWMutex
+	keys       map[string]*keySet
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// keySet holds the versions of a key. A plain key file is version 0.
+type keySet struct {
+	latest   int
+	versions map[int]*keyEntry
+}
+
+type keyEntry struct {
+	modTime time.Time
+	size    int64
+	buf     *lockedBuffer
+}
+
+// borrowedKey hands a cached key to aesutils without copying it out of
+// locked memory. It is only valid inside the function given to withKey.
+type borrowedKey struct {
+	key     []byte
+	version int
+}
+
+func (k *borrowedKey) Key() ([]byte, error) {
+	return k.key, nil
+}
+
+func (k *borrowedKey) Version() int {
+	return k.version
+}
+
+func newKeyStore(keyPath string) *keyStore {
+	return &keyStore{
+		keyPath: keyPath,
+		keys:    map[string]*keySet{},
+		stop:    make(chan struct{}),
+	}
+}
+
+// withLatestKey calls fn with the newest version of a key, see withKey
+func (s *keyStore) withLatestKey(keyName string, fn func(key aesutils.AESKey, version int) error) error {
+	return s.withKey(keyName, -1, fn)
+}
+
+// withKey calls fn with a version of a key, -1 for the latest. The key is
+// borrowed from locked memory under the read lock instead of being copied,
+// so fn must not keep it or reload the store.
+func (s *keyStore) withKey(keyName string, version int, fn func(key aesutils.AESKey, version int) error) error {
+	found, err := s.borrow(keyName, version, fn)
+	if !found {
+		// The key may have been added since the last reload
+		reloaded, reloadErr := s.reloadOnMiss()
+		if reloadErr != nil {
+			return reloadErr
+		}
+		if reloaded {
+			found, err = s.borrow(keyName, version, fn)
+		}
+	}
+
+	if !found {
+		if version < 0 {
+			return fmt.Errorf("Key not found: %s", keyName)
+		}
+		return fmt.Errorf("Key not found: %s version %d", keyName, version)
+	}
+
+	return err
+}
+
+// borrow calls fn with a cached key while holding the read lock, it returns
+// false when the key is not cached
+func (s *keyStore) borrow(keyName string, version int, fn func(key aesutils.AESKey, version int) error) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	set, ok := s.keys[keyName]
+	if !ok {
+		return false, nil
+	}
+
+	if version < 0 {
+		version = set.latest
+	}
+
+	entry, ok := set.versions[version]
+	if !ok {
+		return false, nil
+	}
+
+	return true, fn(&borrowedKey{key: entry.buf.bytes(), version: version}, version)
+}
+
+// reloadOnMiss reloads for a lookup that missed the cache, unless the key
+// path was reloaded less than missReloadInterval ago. Other misses meanwhile
+// wait for the next reload by the watch or a later miss.
+func (s *keyStore) reloadOnMiss() (bool, error) {
+	s.reloadLock.Lock()
+	recent := time.Since(s.lastReload) < missReloadInterval
+	s.reloadLock.Unlock()
+
+	if recent {
+		return false, nil
+	}
+
+	return true, s.reload()
+}
+
+// reload loads new and changed key files and evicts removed ones. A key file
+// that changed but no longer parses keeps its last good key.
+func (s *keyStore) reload() error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	s.lastReload = time.Now()
+
+	select {
+	case <-s.stop:
+		return errors.New("Key store is closed")
+	default:
+	}
+
+	found, err := s.scan()
+	if err != nil {
+		return err
+	}
+
+	s.lock.RLock()
+	old := s.keys
+	s.lock.RUnlock()
+
+	keys := map[string]*keySet{}
+	kept := map[*keyEntry]bool{}
+
+	for keyName, versions := range found {
+		set := &keySet{versions: map[int]*keyEntry{}}
+
+		for version, info := range versions {
+			oldEntry := old[keyName].entry(version)
+			if oldEntry != nil && oldEntry.modTime.Equal(info.ModTime()) && oldEntry.size == info.Size() {
+				set.versions[version] = oldEntry
+				kept[oldEntry] = true
+				continue
+			}
+
+			entry, err := loadKeyEntry(s.keyFile(keyName, version), info)
+			if err != nil {
+				log.Errorf("Could not load key: %v", err)
+				if oldEntry != nil {
+					set.versions[version] = oldEntry
+					kept[oldEntry] = true
+				}
+				continue
+			}
+
+			log.Debugf("Loaded key %s version %d", keyName, version)
+			set.versions[version] = entry
+		}
+
+		for version := range set.versions {
+			if version > set.latest {
+				set.latest = version
+			}
+		}
+
+		keys[keyName] = set
+	}
+
+	s.lock.Lock()
+	s.keys = keys
+	s.lock.Unlock()
+
+	// Lookups only borrow keys while holding the read lock, and the swap
+	// above waited for those to finish, so nothing still uses entries that
+	// are gone from the new set
+	for _, set := range old {
+		for _, entry := range set.versions {
+			if !kept[entry] {
+				entry.buf.destroy()
+			}
+		}
+	}
+
+	return nil
+}
+
+// scan lists the key files under the key path. Plain files are unversioned
+// keys, directories hold one numbered file per version. Dotfiles are skipped.
+func (s *keyStore) scan() (map[string]map[int]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(s.keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]map[int]os.FileInfo{}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		if !file.IsDir() {
+			found[file.Name()] = map[int]os.FileInfo{0: file}
+			continue
+		}
+
+		versionFiles, err := ioutil.ReadDir(path.Join(s.keyPath, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		found[file.Name()] = map[int]os.FileInfo{}
+		for _, versionFile := range versionFiles {
+			if versionFile.IsDir() {
+				continue
+			}
+
+			if version, err := strconv.Atoi(versionFile.Name()); err == nil && version > 0 {
+				found[file.Name()][version] = versionFile
+			}
+		}
+	}
+
+	return found, nil
+}
+
+func (s *keyStore) keyFile(keyName string, version int) string {
+	if version == 0 {
+		return path.Join(s.keyPath, keyName)
+	}
+
+	return path.Join(s.keyPath, keyName, strconv.Itoa(version))
+}
+
+// watch reloads the key path every interval until close is called
+func (s *keyStore) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.reload(); err != nil {
+				log.Errorf("Could not reload keys from %s: %v", s.keyPath, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// close stops watching and wipes all cached keys
+func (s *keyStore) close() {
+	s.once.Do(func() {
+		close(s.stop)
+
+		s.reloadLock.Lock()
+		defer s.reloadLock.Unlock()
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		for _, set := range s.keys {
+			for _, entry := range set.versions {
+				entry.buf.destroy()
+			}
+		}
+		s.keys = map[string]*keySet{}
+	})
+}
+
+func (set *keySet) entry(version int) *keyEntry {
+	if set == nil {
+		return nil
+	}
+
+	return set.versions[version]
+}
+
+func loadKeyEntry(keyFile string, info os.FileInfo) (*keyEntry, error) {
+	key, err := readKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+
+	buf, err := newLockedBuffer(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyEntry{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		buf:     buf,
+	}, nil
+}
+
+func readKey(keyFile string) ([]byte, error) {
+	key, err := aesutils.NewAESKeyFromFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Key()
+}
+
+func zero(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
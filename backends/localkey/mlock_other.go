@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd
+
+package localkey
+
+// lockedBuffer holds a key in ordinary memory where locking is not supported
+type lockedBuffer struct {
+	data []byte
+}
+
+func newLockedBuffer(key []byte) (*lockedBuffer, error) {
+	data := make([]byte, len(key))
+	copy(data, key)
+
+	return &lockedBuffer{data: data}, nil
+}
+
+func (b *lockedBuffer) bytes() []byte {
+	return b.data
+}
+
+func (b *lockedBuffer) destroy() {
+	zero(b.data)
+}
@@ -0,0 +1,59 @@
+//go:build linux || darwin || freebsd
+
+package localkey
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+var mlockWarning sync.Once
+
+// lockedBuffer holds a key in its own anonymous mapping, locked so that it
+// is never swapped out. Keys get their own pages so that unlocking one key
+// cannot unlock another.
+type lockedBuffer struct {
+	data   []byte
+	length int
+	locked bool
+}
+
+func newLockedBuffer(key []byte) (*lockedBuffer, error) {
+	pageSize := os.Getpagesize()
+	size := (len(key)/pageSize + 1) * pageSize
+
+	data, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &lockedBuffer{
+		data:   data,
+		length: len(key),
+	}
+
+	if err := syscall.Mlock(data); err != nil {
+		mlockWarning.Do(func() {
+			log.Warnf("Could not lock key memory, keys may be swapped to disk: %v", err)
+		})
+	} else {
+		buf.locked = true
+	}
+
+	copy(data, key)
+	return buf, nil
+}
+
+func (b *lockedBuffer) bytes() []byte {
+	return b.data[:b.length]
+}
+
+func (b *lockedBuffer) destroy() {
+	zero(b.data)
+
+	if b.locked {
+		syscall.Munlock(b.data)
+	}
+	syscall.Munmap(b.data)
+}
@@ -0,0 +1,308 @@
+//go:build pkcs11
+// +build pkcs11
+
+package pkcs11
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/rancher/secrets-api/pkg/aesutils"
+)
+
+const gcmTagBits = 128
+
+var (
+	modulesLock sync.Mutex
+	modules     = map[string]*pkcs11.Ctx{}
+)
+
+// Config holds the token settings
+type Config struct {
+	ModulePath string
+	Slot       uint
+	PIN        string
+	// KeyLabel is the AES master key used when no key name is given
+	KeyLabel string
+	// HMACKeyLabel is the generic secret key used to sign secrets
+	HMACKeyLabel string
+}
+
+// Client implements the backend interface. Data keys are generated locally
+// and wrapped with AES-GCM by a master key that never leaves the token.
+type Client struct {
+	ctx    *pkcs11.Ctx
+	config Config
+}
+
+type internalSecret struct {
+	WrapNonce    []byte `json:"wrapNonce"`
+	EncryptedKey []byte `json:"encryptedKey"`
+	Secret       string `json:"secret"`
+}
+
+// NewClient loads the PKCS#11 module once per process and returns a client
+// for the configured slot
+func NewClient(config Config) (*Client, error) {
+	if config.ModulePath == "" {
+		return nil, errors.New("No PKCS#11 module configured")
+	}
+
+	ctx, err := loadModule(config.ModulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		ctx:    ctx,
+		config: config,
+	}
+
+	// Open and close a session so bad slots or PINs are found early
+	session, err := client.openSession()
+	if err != nil {
+		return nil, err
+	}
+	client.ctx.CloseSession(session)
+
+	return client, nil
+}
+
+// GetEncryptedText encrypts the clear text with a new data key wrapped in the token
+func (c *Client) GetEncryptedText(keyName, clearText string) (string, error) {
+	dataKey, err := aesutils.NewRandomAESKey(32)
+	if err != nil {
+		return "", err
+	}
+
+	keyBytes, err := dataKey.Key()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	encryptedKey, err := c.wrap(c.keyLabel(keyName), nonce, keyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := aesutils.GetEncryptedText(dataKey, clearText, "aes256-gcm")
+	if err != nil {
+		return "", err
+	}
+
+	blob, err := json.Marshal(&internalSecret{
+		WrapNonce:    nonce,
+		EncryptedKey: encryptedKey,
+		Secret:       secret,
+	})
+
+	return string(blob), err
+}
+
+// GetClearText unwraps the data key in the token and decrypts the secret
+func (c *Client) GetClearText(keyName, cipherText string) (string, error) {
+	secret := &internalSecret{}
+	if err := json.Unmarshal([]byte(cipherText), secret); err != nil {
+		return "", err
+	}
+
+	keyBytes, err := c.unwrap(c.keyLabel(keyName), secret.WrapNonce, secret.EncryptedKey)
+	if err != nil {
+		return "", err
+	}
+
+	return aesutils.GetClearText(aesutils.NewAESKeyFromBytes(keyBytes), secret.Secret)
+}
+
+// Sign creates an HMAC of a nonce and the clear text inside the token
+func (c *Client) Sign(keyName, clearText string) (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b64Nonce := base64.StdEncoding.EncodeToString(nonce)
+
+	var signature []byte
+	err := c.withKey(c.config.HMACKeyLabel, func(session pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_HMAC, nil)}
+		if err := c.ctx.SignInit(session, mech, key); err != nil {
+			return err
+		}
+
+		var err error
+		signature, err = c.ctx.Sign(session, []byte(b64Nonce+":"+clearText))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return b64Nonce + ":" + base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifySignature verifies the HMAC inside the token
+func (c *Client) VerifySignature(keyName, signature, message string) (bool, error) {
+	sigSplit := strings.SplitN(signature, ":", 2)
+	if len(sigSplit) != 2 {
+		return false, errors.New("Invalid signature format")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(sigSplit[1])
+	if err != nil {
+		return false, err
+	}
+
+	valid := false
+	err = c.withKey(c.config.HMACKeyLabel, func(session pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_HMAC, nil)}
+		if err := c.ctx.VerifyInit(session, mech, key); err != nil {
+			return err
+		}
+
+		err := c.ctx.Verify(session, []byte(sigSplit[0]+":"+message), sig)
+		if err == pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID) || err == pkcs11.Error(pkcs11.CKR_SIGNATURE_LEN_RANGE) {
+			return nil
+		}
+
+		valid = err == nil
+		return err
+	})
+
+	return valid, err
+}
+
+// Delete No op nothing stored
+func (c *Client) Delete(keyName, cipherText string) error {
+	return nil
+}
+
+func (c *Client) keyLabel(keyName string) string {
+	if keyName != "" {
+		return keyName
+	}
+	return c.config.KeyLabel
+}
+
+func (c *Client) wrap(label string, nonce, keyBytes []byte) ([]byte, error) {
+	var encrypted []byte
+	err := c.withKey(label, func(session pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+		params := pkcs11.NewGCMParams(nonce, nil, gcmTagBits)
+		defer params.Free()
+
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+		if err := c.ctx.EncryptInit(session, mech, key); err != nil {
+			return err
+		}
+
+		var err error
+		encrypted, err = c.ctx.Encrypt(session, keyBytes)
+		return err
+	})
+
+	return encrypted, err
+}
+
+func (c *Client) unwrap(label string, nonce, encrypted []byte) ([]byte, error) {
+	var keyBytes []byte
+	err := c.withKey(label, func(session pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+		params := pkcs11.NewGCMParams(nonce, nil, gcmTagBits)
+		defer params.Free()
+
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+		if err := c.ctx.DecryptInit(session, mech, key); err != nil {
+			return err
+		}
+
+		var err error
+		keyBytes, err = c.ctx.Decrypt(session, encrypted)
+		return err
+	})
+
+	return keyBytes, err
+}
+
+// withKey runs fn in a fresh session with the secret key carrying label.
+// Sessions are not safe for concurrent use, so each operation gets its own.
+func (c *Client) withKey(label string, fn func(pkcs11.SessionHandle, pkcs11.ObjectHandle) error) error {
+	if label == "" {
+		return errors.New("No PKCS#11 key label given")
+	}
+
+	session, err := c.openSession()
+	if err != nil {
+		return err
+	}
+	defer c.ctx.CloseSession(session)
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	if err := c.ctx.FindObjectsInit(session, template); err != nil {
+		return err
+	}
+
+	objects, _, err := c.ctx.FindObjects(session, 1)
+	c.ctx.FindObjectsFinal(session)
+	if err != nil {
+		return err
+	}
+
+	if len(objects) == 0 {
+		return fmt.Errorf("No PKCS#11 key with label: %s", label)
+	}
+
+	return fn(session, objects[0])
+}
+
+func (c *Client) openSession() (pkcs11.SessionHandle, error) {
+	session, err := c.ctx.OpenSession(c.config.Slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return session, err
+	}
+
+	// Login state is shared by all sessions of the application
+	err = c.ctx.Login(session, pkcs11.CKU_USER, c.config.PIN)
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		c.ctx.CloseSession(session)
+		return session, err
+	}
+
+	return session, nil
+}
+
+// loadModule initializes a PKCS#11 module once, C_Initialize may only be
+// called once per process.
+func loadModule(modulePath string) (*pkcs11.Ctx, error) {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	if ctx, ok := modules[modulePath]; ok {
+		return ctx, nil
+	}
+
+	ctx := pkcs11.New(modulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("Could not load PKCS#11 module: %s", modulePath)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+
+	modules[modulePath] = ctx
+	return ctx, nil
+}
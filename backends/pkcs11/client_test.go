@@ -0,0 +1,69 @@
+//go:build pkcs11
+// +build pkcs11
+
+package pkcs11
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+const secretText = "my secret to keep"
+
+// TestPKCS11Client runs against a SoftHSM token, e.g.
+//
+//	softhsm2-util --init-token --slot 0 --label test --pin 1234 --so-pin 1234
+//
+// with an AES key and a generic secret key created in it, and
+//
+//	PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_SLOT=<slot> PKCS11_PIN=1234
+//	PKCS11_KEY_LABEL=aes PKCS11_HMAC_KEY_LABEL=hmac go test -tags pkcs11 ./backends/pkcs11/
+func TestPKCS11Client(t *testing.T) {
+	if os.Getenv("PKCS11_MODULE") == "" {
+		t.Skip("PKCS11_MODULE not set")
+	}
+
+	slot, err := strconv.ParseUint(os.Getenv("PKCS11_SLOT"), 10, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(Config{
+		ModulePath:   os.Getenv("PKCS11_MODULE"),
+		Slot:         uint(slot),
+		PIN:          os.Getenv("PKCS11_PIN"),
+		KeyLabel:     os.Getenv("PKCS11_KEY_LABEL"),
+		HMACKeyLabel: os.Getenv("PKCS11_HMAC_KEY_LABEL"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encData, err := client.GetEncryptedText("", secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := client.GetClearText("", encData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data != secretText {
+		t.Errorf("Secret data decrypted to '%s' and we expected '%s'", data, secretText)
+	}
+
+	signature, err := client.Sign("", secretText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if match, err := client.VerifySignature("", signature, secretText); !match || err != nil {
+		t.Errorf("Signature did not verify: %v", err)
+	}
+
+	if match, _ := client.VerifySignature("", signature, "tampered"); match {
+		t.Error("Signature verified against the wrong message")
+	}
+}
@@ -0,0 +1,327 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// DefaultKubernetesJWTFile is where Kubernetes mounts the service account token
+const DefaultKubernetesJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Config selects the Vault server and how to authenticate to it. Exactly one
+// of Token, AppRole and Kubernetes must be set.
+type Config struct {
+	URL        string
+	Token      string
+	AppRole    *AppRoleAuth
+	Kubernetes *KubernetesAuth
+}
+
+// AppRoleAuth logs in with a role_id and secret_id read from files. The
+// files are read again on every login so the secret_id can be rotated.
+type AppRoleAuth struct {
+	RoleIDFile   string
+	SecretIDFile string
+	// MountPath defaults to "approle"
+	MountPath string
+}
+
+// KubernetesAuth logs in with the pod's service account JWT
+type KubernetesAuth struct {
+	Role string
+	// JWTFile defaults to DefaultKubernetesJWTFile
+	JWTFile string
+	// MountPath defaults to "kubernetes"
+	MountPath string
+}
+
+type loginMethod interface {
+	// loginPath and loginData make up the login request
+	loginPath() string
+	loginData() (map[string]interface{}, error)
+}
+
+func (c Config) loginMethod() (loginMethod, error) {
+	methods := 0
+	var login loginMethod
+
+	if c.Token != "" {
+		methods++
+	}
+	if c.AppRole != nil {
+		methods++
+		login = c.AppRole
+	}
+	if c.Kubernetes != nil {
+		methods++
+		login = c.Kubernetes
+	}
+
+	if methods != 1 {
+		return nil, errors.New("Vault needs exactly one of a token, AppRole or Kubernetes auth")
+	}
+
+	return login, nil
+}
+
+func (a *AppRoleAuth) loginPath() string {
+	return "auth/" + mountPath(a.MountPath, "approle") + "/login"
+}
+
+func (a *AppRoleAuth) loginData() (map[string]interface{}, error) {
+	roleID, err := readCredential(a.RoleIDFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role_id": roleID,
+	}
+
+	// Roles may be configured to not require a secret_id
+	if a.SecretIDFile != "" {
+		if data["secret_id"], err = readCredential(a.SecretIDFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+func (k *KubernetesAuth) loginPath() string {
+	return "auth/" + mountPath(k.MountPath, "kubernetes") + "/login"
+}
+
+func (k *KubernetesAuth) loginData() (map[string]interface{}, error) {
+	jwtFile := k.JWTFile
+	if jwtFile == "" {
+		jwtFile = DefaultKubernetesJWTFile
+	}
+
+	jwt, err := readCredential(jwtFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"role": k.Role,
+		"jwt":  jwt,
+	}, nil
+}
+
+// authenticate logs in with a client that sends no token
+func (v *Client) authenticate() (*api.SecretAuth, error) {
+	data, err := v.login.loginData()
+	if err != nil {
+		return nil, err
+	}
+
+	loginClient, err := newAPIClient(v.config.URL, "")
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := loginClient.Logical().Write(v.login.loginPath(), data)
+	if err != nil {
+		return nil, fmt.Errorf("Vault login failed: %v", err)
+	}
+
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, errors.New("Vault login returned no token")
+	}
+
+	log.Debugf("Logged in to Vault at %s", v.login.loginPath())
+	return secret.Auth, nil
+}
+
+// refreshToken renews the current token, falling back to a new login when
+// the token is not renewable or renewal fails
+func (v *Client) refreshToken(renewable bool) (time.Duration, bool, error) {
+	var err error
+
+	if renewable {
+		var secret *api.Secret
+		secret, err = v.currentClient().Auth().Token().RenewSelf(0)
+		if err == nil && secret.Auth != nil {
+			log.Debugf("Renewed Vault token")
+			return time.Duration(secret.Auth.LeaseDuration) * time.Second, secret.Auth.Renewable, nil
+		}
+		if err == nil {
+			err = errors.New("No auth in renewal response")
+		}
+	} else {
+		err = errors.New("Vault token is not renewable")
+	}
+
+	if v.login == nil {
+		return 0, false, err
+	}
+
+	log.Debugf("Logging in to Vault again: %v", err)
+
+	auth, err := v.authenticate()
+	if err != nil {
+		return 0, false, err
+	}
+
+	client, err := newAPIClient(v.config.URL, auth.ClientToken)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// The API client does not guard its token, so a new token gets a new client
+	v.lock.Lock()
+	v.client = client
+	v.lock.Unlock()
+
+	return time.Duration(auth.LeaseDuration) * time.Second, auth.Renewable, nil
+}
+
+// renewToken refreshes the token once two thirds of its TTL have passed.
+// Failures are retried, and once the token has expired Health reports the
+// failure until a refresh succeeds. A static token that expired is given up.
+func (v *Client) renewToken(ttl time.Duration, renewable bool) {
+	expires := time.Now().Add(ttl)
+	next := ttl * 2 / 3
+
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-time.After(next):
+		}
+
+		newTTL, newRenewable, err := v.refreshToken(renewable)
+		if err == nil {
+			v.setTokenError(nil)
+
+			if newTTL <= 0 {
+				return
+			}
+
+			ttl, renewable = newTTL, newRenewable
+			expires = time.Now().Add(ttl)
+			next = ttl * 2 / 3
+			continue
+		}
+
+		remaining := time.Until(expires)
+		if remaining <= 0 {
+			v.setTokenError(fmt.Errorf("Vault token expired after renewal failed: %v", err))
+			log.Errorf("Vault token expired after renewal failed: %v", err)
+
+			if v.login == nil {
+				return
+			}
+
+			next = 30 * time.Second
+			continue
+		}
+
+		log.Errorf("Could not renew Vault token, expires in %s: %v", remaining, err)
+		next = remaining / 3
+		if next > 30*time.Second {
+			next = 30 * time.Second
+		}
+	}
+}
+
+func (v *Client) setTokenError(err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.tokenErr = err
+}
+
+func (v *Client) currentClient() *api.Client {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	return v.client
+}
+
+func (v *Client) getVaultClient() (*api.Client, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	if v.tokenErr != nil {
+		return nil, v.tokenErr
+	}
+
+	return v.client, nil
+}
+
+func newAPIClient(url, token string) (*api.Client, error) {
+	config := api.DefaultConfig()
+	config.Address = url
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+
+	return client, nil
+}
+
+func storageDir(lookup *api.Secret) string {
+	if lookup == nil {
+		return ""
+	}
+
+	if meta, ok := lookup.Data["meta"].(map[string]interface{}); ok {
+		if storageDir, ok := meta["storage_dir"].(string); ok {
+			return storageDir
+		}
+	}
+
+	return ""
+}
+
+// tokenTTL reads the remaining TTL of a token lookup, 0 for tokens that
+// never expire
+func tokenTTL(lookup *api.Secret) (time.Duration, bool) {
+	if lookup == nil {
+		return 0, false
+	}
+
+	renewable, _ := lookup.Data["renewable"].(bool)
+
+	var seconds int64
+	switch ttl := lookup.Data["ttl"].(type) {
+	case json.Number:
+		seconds, _ = ttl.Int64()
+	case float64:
+		seconds = int64(ttl)
+	}
+
+	return time.Duration(seconds) * time.Second, renewable
+}
+
+func mountPath(path, defaultPath string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return defaultPath
+	}
+	return path
+}
+
+func readCredential(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	credential := strings.TrimSpace(string(data))
+	if credential == "" {
+		return "", fmt.Errorf("Credential file %s is empty", file)
+	}
+
+	return credential, nil
+}
@@ -0,0 +1,186 @@
+package vault
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const nonceLength = 8
+
+// BatchGetEncryptedText encrypts many clear texts with one Transit call
+func (v *Client) BatchGetEncryptedText(keyName string, clearTexts []string) ([]string, error) {
+	inputs := make([]map[string]interface{}, len(clearTexts))
+	for i, clearText := range clearTexts {
+		inputs[i] = map[string]interface{}{"plaintext": clearText}
+	}
+
+	results, err := v.batchWrite(fmt.Sprintf("/transit/encrypt/%s", keyName), inputs)
+	if err != nil {
+		log.Error(err)
+		return nil, fmt.Errorf("Issue encrypting with %s key", keyName)
+	}
+
+	cipherTexts := make([]string, len(results))
+	for i, result := range results {
+		cipherText, ok := result["ciphertext"].(string)
+		if !ok || cipherText == "" {
+			return nil, errors.New("Could not encrypt cleartext")
+		}
+
+		if v.storageDir != "" {
+			if cipherText, err = v.storeSecretInVault(cipherText); err != nil {
+				return nil, err
+			}
+		}
+		cipherTexts[i] = cipherText
+	}
+
+	return cipherTexts, nil
+}
+
+// BatchGetClearText decrypts many cipher texts with one Transit call
+func (v *Client) BatchGetClearText(keyName string, cipherTexts []string) ([]string, error) {
+	inputs := make([]map[string]interface{}, len(cipherTexts))
+	for i, cipherText := range cipherTexts {
+		if v.storageDir != "" {
+			var err error
+			if cipherText, err = v.retrieveSecretFromVault(cipherText); err != nil {
+				return nil, err
+			}
+		}
+		inputs[i] = map[string]interface{}{"ciphertext": cipherText}
+	}
+
+	results, err := v.batchWrite(fmt.Sprintf("/transit/decrypt/%s", keyName), inputs)
+	if err != nil {
+		log.Error(err)
+		return nil, fmt.Errorf("Issue decrypting secret with %s key", keyName)
+	}
+
+	clearTexts := make([]string, len(results))
+	for i, result := range results {
+		plainText, ok := result["plaintext"].(string)
+		if !ok || plainText == "" {
+			return nil, errors.New("Could not decrypt ciphertext")
+		}
+		clearTexts[i] = plainText
+	}
+
+	return clearTexts, nil
+}
+
+// BatchSign signs many clear texts with one call for the nonces and one
+// Transit HMAC call. Signatures have the same format as Sign.
+func (v *Client) BatchSign(keyName string, clearTexts []string) ([]string, error) {
+	nonceResp, err := v.writeToVault(fmt.Sprintf("/transit/random/%d", nonceLength*len(clearTexts)), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	encodedNonces, _ := nonceResp.Data["random_bytes"].(string)
+	nonceBytes, err := base64.StdEncoding.DecodeString(encodedNonces)
+	if err != nil || len(nonceBytes) != nonceLength*len(clearTexts) {
+		return nil, errors.New("Could not generate nonce")
+	}
+
+	nonces := make([]string, len(clearTexts))
+	inputs := make([]map[string]interface{}, len(clearTexts))
+	for i, clearText := range clearTexts {
+		nonces[i] = base64.StdEncoding.EncodeToString(nonceBytes[i*nonceLength : (i+1)*nonceLength])
+		input, _ := formatSignatureString(nonces[i], clearText)
+		inputs[i] = map[string]interface{}{"input": input}
+	}
+
+	results, err := v.batchWriteData(fmt.Sprintf("/transit/hmac/%s", keyName), map[string]interface{}{
+		"algorithm": "sha2-256",
+	}, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := make([]string, len(results))
+	for i, result := range results {
+		signature, ok := result["hmac"].(string)
+		if !ok || signature == "" {
+			return nil, errors.New("Could not get a signature")
+		}
+		signatures[i] = nonces[i] + ":" + signature
+	}
+
+	return signatures, nil
+}
+
+// BatchVerifySignature verifies many signatures with one Transit call
+func (v *Client) BatchVerifySignature(keyName string, signatures, messages []string) ([]bool, error) {
+	if len(signatures) != len(messages) {
+		return nil, errors.New("Signature and message counts differ")
+	}
+
+	inputs := make([]map[string]interface{}, len(signatures))
+	for i, signature := range signatures {
+		sigSplit := strings.SplitN(signature, ":", 2)
+		if len(sigSplit) != 2 {
+			return nil, errors.New("Invalid signature format")
+		}
+
+		input, _ := formatSignatureString(sigSplit[0], messages[i])
+		inputs[i] = map[string]interface{}{
+			"input": input,
+			"hmac":  sigSplit[1],
+		}
+	}
+
+	results, err := v.batchWrite(fmt.Sprintf("/transit/verify/%s/sha2-256", keyName), inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	verified := make([]bool, len(results))
+	for i, result := range results {
+		verified[i], _ = result["valid"].(bool)
+	}
+
+	return verified, nil
+}
+
+func (v *Client) batchWrite(path string, inputs []map[string]interface{}) ([]map[string]interface{}, error) {
+	return v.batchWriteData(path, map[string]interface{}{}, inputs)
+}
+
+// batchWriteData sends inputs as Transit's batch_input and returns the
+// batch_results in the same order. A failed item fails the whole batch.
+func (v *Client) batchWriteData(path string, data map[string]interface{}, inputs []map[string]interface{}) ([]map[string]interface{}, error) {
+	data["batch_input"] = inputs
+
+	secret, err := v.writeToVault(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("No batch results from %s", path)
+	}
+
+	results, ok := secret.Data["batch_results"].([]interface{})
+	if !ok || len(results) != len(inputs) {
+		return nil, fmt.Errorf("Expected %d batch results from %s", len(inputs), path)
+	}
+
+	batchResults := make([]map[string]interface{}, len(results))
+	for i, result := range results {
+		batchResult, ok := result.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid batch result from %s", path)
+		}
+
+		if itemErr, _ := batchResult["error"].(string); itemErr != "" {
+			return nil, fmt.Errorf("Batch item %d failed: %s", i, itemErr)
+		}
+
+		batchResults[i] = batchResult
+	}
+
+	return batchResults, nil
+}
@@ -5,38 +5,98 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"encoding/base64"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/hashicorp/vault/api"
+	"github.com/rancher/secrets-api/pkg/logutils"
 )
 
-// Client is the struct that implements the backend interface
+var log = logutils.New("vault")
+
+// Client is the struct that implements the backend interface. It holds a
+// single Vault API client and keeps its token renewed.
 type Client struct {
-	url        string
-	token      string
+	config     Config
+	login      loginMethod
 	storageDir string
+
+	lock     sync.RWMutex
+	client   *api.Client
+	tokenErr error
+
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewClient returns a Client type that is ready to interact
 // with vault
 func NewClient(url, token string) (*Client, error) {
-	var err error
+	return NewClientWithConfig(Config{URL: url, Token: token})
+}
+
+// NewClientWithConfig returns a Client that authenticates with the method
+// in config. Tokens from a login are renewed and, once they can no longer
+// be renewed, replaced by logging in again.
+func NewClientWithConfig(config Config) (*Client, error) {
+	login, err := config.loginMethod()
+	if err != nil {
+		return nil, err
+	}
 
 	client := &Client{
-		url:   url,
-		token: token,
+		config: config,
+		login:  login,
+		stop:   make(chan struct{}),
+	}
+
+	token := config.Token
+	if login != nil {
+		auth, err := client.authenticate()
+		if err != nil {
+			return nil, err
+		}
+		token = auth.ClientToken
+	}
+
+	client.client, err = newAPIClient(config.URL, token)
+	if err != nil {
+		return nil, err
 	}
 
-	client.storageDir, err = client.getStorageDir()
+	lookup, err := client.client.Auth().Token().LookupSelf()
 	if err != nil {
 		return client, err
 	}
 
+	client.storageDir = storageDir(lookup)
+
+	ttl, renewable := tokenTTL(lookup)
+	if ttl > 0 {
+		go client.renewToken(ttl, renewable)
+	}
+
 	return client, nil
 }
 
+// Health returns an error once the token has expired and could not be
+// renewed. Requests fail with the same error until then.
+func (v *Client) Health() error {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	return v.tokenErr
+}
+
+// Close stops renewing the token
+func (v *Client) Close() error {
+	v.closeOnce.Do(func() {
+		close(v.stop)
+	})
+	return nil
+}
+
 // GetEncryptedText None Client just returns the clearText
 func (v *Client) GetEncryptedText(keyName, clearText string) (string, error) {
 	encryptPath := fmt.Sprintf("/transit/encrypt/%s", keyName)
@@ -47,7 +107,7 @@ func (v *Client) GetEncryptedText(keyName, clearText string) (string, error) {
 
 	secret, err := v.writeToVault(encryptPath, data)
 	if err != nil {
-		logrus.Error(err)
+		log.Error(err)
 		return "", fmt.Errorf("Issue encrypting with %s key", keyName)
 	}
 
@@ -78,7 +138,7 @@ func (v *Client) GetClearText(keyName, cipherText string) (string, error) {
 
 	secret, err := v.writeToVault(decryptPath, map[string]interface{}{"ciphertext": cipherText})
 	if err != nil {
-		logrus.Error(err)
+		log.Error(err)
 		return "", fmt.Errorf("Issue decrypting secret with %s key", keyName)
 	}
 
@@ -123,7 +183,7 @@ func (v *Client) Sign(keyName, clearText string) (string, error) {
 // VerifySignature verifies the signature
 func (v *Client) VerifySignature(keyName, signature, message string) (bool, error) {
 	comparePath := fmt.Sprintf("/transit/verify/%s/sha2-256", keyName)
-	logrus.Debugf("Vault Backend: verify signature: %s against key %s", signature, keyName)
+	log.Debugf("Verifying signature against key %s", keyName)
 
 	sigSplit := strings.SplitN(signature, ":", 2)
 	if len(sigSplit) != 2 {
@@ -175,38 +235,6 @@ func (v *Client) writeToVault(path string, data map[string]interface{}) (*api.Se
 	return vaultClient.Logical().Write(path, data)
 }
 
-func (v *Client) getStorageDir() (string, error) {
-	tokenLookupData := map[string]interface{}{
-		"token": v.token,
-	}
-
-	secret, err := v.writeToVault("/auth/token/lookup", tokenLookupData)
-	if err != nil {
-		return "", err
-	}
-
-	if meta, ok := secret.Data["meta"].(map[string]interface{}); ok {
-		if storageDir, ok := meta["storage_dir"]; ok {
-			return storageDir.(string), nil
-		}
-	}
-
-	return "", nil
-}
-
-func (v *Client) getVaultClient() (*api.Client, error) {
-	config := api.DefaultConfig()
-	config.Address = v.url
-
-	client, err := api.NewClient(config)
-	if err != nil {
-		return nil, err
-	}
-	client.SetToken(v.token)
-
-	return client, nil
-}
-
 func testVaultTransitKeyExists(vaultCli *api.Client, keyName string) (bool, error) {
 	exists := false
 	keyPath := fmt.Sprintf("/transit/keys/%s", keyName)
@@ -258,7 +286,11 @@ func (v *Client) retrieveSecretFromVault(path string) (string, error) {
 		return "", err
 	}
 
-	logrus.Debugf("%#v", secret)
+	if secret == nil {
+		return "", fmt.Errorf("No secret stored at %s", path)
+	}
+
+	log.Debugf("Read stored cipher text from %s", path)
 	if text, ok := secret.Data["cipherText"]; ok {
 		return text.(string), nil
 	}
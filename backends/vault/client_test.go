@@ -0,0 +1,289 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/secrets-api/pkg/testutils"
+)
+
+type fakeVault struct {
+	sync.Mutex
+	calls      map[string]int
+	renewFails bool
+	logins     int
+	lastToken  string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	// The client joins paths with a leading slash onto /v1/
+	urlPath := path.Clean(r.URL.Path)
+	f.calls[urlPath]++
+
+	var resp interface{}
+	switch urlPath {
+	case "/v1/auth/token/lookup-self":
+		renewable := !strings.HasPrefix(r.Header.Get("X-Vault-Token"), "login-")
+		resp = map[string]interface{}{
+			"data": map[string]interface{}{"ttl": 1, "renewable": renewable},
+		}
+	case "/v1/auth/token/renew-self":
+		if f.renewFails {
+			w.WriteHeader(http.StatusForbidden)
+			resp = map[string]interface{}{"errors": []string{"permission denied"}}
+			break
+		}
+		resp = map[string]interface{}{
+			"auth": map[string]interface{}{"lease_duration": 1, "renewable": true},
+		}
+	case "/v1/auth/approle/login":
+		var data map[string]string
+		json.NewDecoder(r.Body).Decode(&data)
+		if r.Header.Get("X-Vault-Token") != "" || data["role_id"] != "role" || data["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			resp = map[string]interface{}{"errors": []string{"invalid login"}}
+			break
+		}
+		f.logins++
+		resp = map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   fmt.Sprintf("login-%d", f.logins),
+				"lease_duration": 1,
+				"renewable":      false,
+			},
+		}
+	case "/v1/transit/encrypt/testing":
+		f.lastToken = r.Header.Get("X-Vault-Token")
+		resp = map[string]interface{}{
+			"data": map[string]interface{}{"ciphertext": "vault:v1:abc"},
+		}
+		if batch := batchInput(r); batch != nil {
+			resp = batchResults(batch, func(item map[string]string) map[string]interface{} {
+				return map[string]interface{}{"ciphertext": "vault:v1:" + item["plaintext"]}
+			})
+		}
+	case "/v1/transit/decrypt/testing":
+		resp = batchResults(batchInput(r), func(item map[string]string) map[string]interface{} {
+			return map[string]interface{}{"plaintext": strings.TrimPrefix(item["ciphertext"], "vault:v1:")}
+		})
+	case "/v1/transit/hmac/testing":
+		resp = batchResults(batchInput(r), func(item map[string]string) map[string]interface{} {
+			return map[string]interface{}{"hmac": fakeHMAC(item["input"])}
+		})
+	case "/v1/transit/verify/testing/sha2-256":
+		resp = batchResults(batchInput(r), func(item map[string]string) map[string]interface{} {
+			return map[string]interface{}{"valid": item["hmac"] == fakeHMAC(item["input"])}
+		})
+	case "/v1/transit/random/16":
+		resp = map[string]interface{}{
+			"data": map[string]interface{}{"random_bytes": base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))},
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		resp = map[string]interface{}{"errors": []string{"not found"}}
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func batchInput(r *http.Request) []map[string]string {
+	var body struct {
+		BatchInput []map[string]string `json:"batch_input"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	return body.BatchInput
+}
+
+func batchResults(batch []map[string]string, result func(map[string]string) map[string]interface{}) interface{} {
+	results := []interface{}{}
+	for _, item := range batch {
+		results = append(results, result(item))
+	}
+
+	return map[string]interface{}{
+		"data": map[string]interface{}{"batch_results": results},
+	}
+}
+
+func fakeHMAC(input string) string {
+	sum := sha256.Sum256([]byte(input))
+	return "vault:v1:" + hex.EncodeToString(sum[:])
+}
+
+func (f *fakeVault) count(path string) int {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.calls[path]
+}
+
+func TestTokenRenewal(t *testing.T) {
+	fake := &fakeVault{calls: map[string]int{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetEncryptedText("testing", "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lookups := fake.count("/v1/auth/token/lookup-self"); lookups != 1 {
+		t.Errorf("Expected a single token lookup, got %d", lookups)
+	}
+
+	testutils.WaitFor(t, "the token to be renewed", func() bool {
+		return fake.count("/v1/auth/token/renew-self") > 0
+	})
+
+	if err := client.Health(); err != nil {
+		t.Errorf("Expected a healthy client after renewal: %v", err)
+	}
+
+	fake.Lock()
+	fake.renewFails = true
+	fake.Unlock()
+
+	testutils.WaitFor(t, "the token to expire", func() bool {
+		return client.Health() != nil
+	})
+
+	if _, err := client.GetEncryptedText("testing", "secret"); err == nil {
+		t.Error("Expected requests to fail once the token expired")
+	}
+}
+
+func TestAppRoleLogin(t *testing.T) {
+	fake := &fakeVault{calls: map[string]int{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "role_id"), []byte("role\n"), 0600)
+	ioutil.WriteFile(path.Join(dir, "secret_id"), []byte("secret\n"), 0600)
+
+	client, err := NewClientWithConfig(Config{
+		URL: server.URL,
+		AppRole: &AppRoleAuth{
+			RoleIDFile:   path.Join(dir, "role_id"),
+			SecretIDFile: path.Join(dir, "secret_id"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.GetEncryptedText("testing", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Lock()
+	token := fake.lastToken
+	fake.Unlock()
+
+	if token != "login-1" {
+		t.Errorf("Expected the token from the login, got %q", token)
+	}
+
+	// The login token is not renewable, so the client has to log in again.
+	// The fake counts the login before the client swaps in the new token, so
+	// wait for a request that uses it.
+	testutils.WaitFor(t, "requests to use the token of a second login", func() bool {
+		if _, err := client.GetEncryptedText("testing", "secret"); err != nil {
+			t.Fatal(err)
+		}
+
+		fake.Lock()
+		defer fake.Unlock()
+		return fake.logins > 1 && fake.lastToken != "login-1"
+	})
+
+	if _, err := NewClientWithConfig(Config{URL: server.URL, Token: "token", AppRole: &AppRoleAuth{}}); err == nil {
+		t.Error("Expected a config with two auth methods to be rejected")
+	}
+}
+
+func TestBatchOperations(t *testing.T) {
+	fake := &fakeVault{calls: map[string]int{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	clearTexts := []string{"aGVsbG8=", "d29ybGQ="}
+
+	cipherTexts, err := client.BatchGetEncryptedText("testing", clearTexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signatures, err := client.BatchSign("testing", clearTexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := client.BatchGetClearText("testing", cipherTexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := client.BatchVerifySignature("testing", signatures, decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range clearTexts {
+		if decrypted[i] != clearTexts[i] {
+			t.Errorf("Decrypted %s, expected %s", decrypted[i], clearTexts[i])
+		}
+		if !verified[i] {
+			t.Errorf("Signature %d did not verify", i)
+		}
+	}
+
+	if signatures[0] == signatures[1] {
+		t.Error("Expected each signature to have its own nonce")
+	}
+
+	for _, op := range []string{"encrypt/testing", "decrypt/testing", "hmac/testing", "verify/testing/sha2-256"} {
+		if calls := fake.count("/v1/transit/" + op); calls != 1 {
+			t.Errorf("Expected one call to %s, got %d", op, calls)
+		}
+	}
+
+	verified, err = client.BatchVerifySignature("testing", signatures, []string{clearTexts[1], clearTexts[0]})
+	if err != nil || verified[0] || verified[1] {
+		t.Errorf("Expected swapped messages to fail verification: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package client is a Go client for the v1-secrets API.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rancher/secrets-api/secrets"
+)
+
+// Client talks to a secrets-api server. Servers started with token or JWT
+// authentication need Token, for mTLS give HTTPClient a transport with the
+// client certificate.
+type Client struct {
+	url        string
+	HTTPClient *http.Client
+
+	// Token is sent as "Authorization: Bearer <Token>" when set
+	Token string
+}
+
+// APIError is returned when the server answers with an error object
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("secrets-api returned %d: %s", e.StatusCode, e.Message)
+}
+
+// NewClient returns a client for the server at url, e.g. http://127.0.0.1:8181
+func NewClient(url string) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(url, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Create encrypts a secret
+func (c *Client) Create(secret *secrets.UnencryptedSecret) (*secrets.EncryptedSecret, error) {
+	encrypted := &secrets.EncryptedSecret{}
+	return encrypted, c.post("create", false, secret, encrypted)
+}
+
+// BulkCreate encrypts multiple secrets
+func (c *Client) BulkCreate(bulk *secrets.BulkSecretInput) (*secrets.BulkEncryptedSecret, error) {
+	encrypted := &secrets.BulkEncryptedSecret{}
+	return encrypted, c.post("create", true, bulk, encrypted)
+}
+
+// Rewrap encrypts a secret for the holder of the private key matching rewrapKey
+func (c *Client) Rewrap(secret *secrets.EncryptedSecret, rewrapKey string) (*secrets.RewrappedSecret, error) {
+	input := *secret
+	input.RewrapKey = rewrapKey
+
+	rewrapped := &secrets.RewrappedSecret{}
+	return rewrapped, c.post("rewrap", false, &input, rewrapped)
+}
+
+// BulkRewrap encrypts multiple secrets for the holder of the private key matching rewrapKey
+func (c *Client) BulkRewrap(bulk *secrets.BulkEncryptedSecret, rewrapKey string) (*secrets.BulkRewrappedSecret, error) {
+	input := *bulk
+	input.RewrapKey = rewrapKey
+
+	rewrapped := &secrets.BulkRewrappedSecret{}
+	return rewrapped, c.post("rewrap", true, &input, rewrapped)
+}
+
+// Reencrypt seals a secret again under the current version of its key
+func (c *Client) Reencrypt(secret *secrets.EncryptedSecret) (*secrets.EncryptedSecret, error) {
+	encrypted := &secrets.EncryptedSecret{}
+	return encrypted, c.post("reencrypt", false, secret, encrypted)
+}
+
+// Migrate seals a secret with a different backend and key
+func (c *Client) Migrate(secret *secrets.EncryptedSecret, backend, keyName string) (*secrets.EncryptedSecret, error) {
+	input := *secret
+	input.MigrateBackend = backend
+	input.MigrateKeyName = keyName
+
+	encrypted := &secrets.EncryptedSecret{}
+	return encrypted, c.post("migrate", false, &input, encrypted)
+}
+
+// Purge lets the backend clear out any data stored for the secret
+func (c *Client) Purge(secret *secrets.EncryptedSecret) error {
+	return c.post("purge", false, secret, nil)
+}
+
+// BulkPurge lets the backend clear out any data stored for the secrets
+func (c *Client) BulkPurge(bulk *secrets.BulkEncryptedSecret) error {
+	return c.post("purge", true, bulk, nil)
+}
+
+// ServerKey fetches the public key rewrap envelopes are signed with. Pin
+// the key ID rather than trusting whatever the server returns.
+func (c *Client) ServerKey() (*secrets.ServerKey, error) {
+	serverKey := &secrets.ServerKey{}
+	return serverKey, c.get("/v1-secrets/keys/server", serverKey)
+}
+
+func (c *Client) get(path string, output interface{}) error {
+	req, err := http.NewRequest("GET", c.url+path, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, output)
+}
+
+func (c *Client) post(action string, bulk bool, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	url := c.url + "/v1-secrets/secrets/" + action
+	if bulk {
+		url += "?action=bulk"
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.do(req, output)
+}
+
+func (c *Client) do(req *http.Request, output interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+
+		errResp := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Message != "" {
+			apiErr.Message = errResp.Message
+		} else {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+
+		return apiErr
+	}
+
+	if output == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.Unmarshal(respBody, output)
+}
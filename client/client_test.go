@@ -0,0 +1,344 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/secrets-api/backends"
+	"github.com/rancher/secrets-api/pkg/rsautils"
+	"github.com/rancher/secrets-api/pkg/signutils"
+	"github.com/rancher/secrets-api/secrets"
+	"github.com/rancher/secrets-api/service"
+)
+
+func TestCreateRewrapUnwrap(t *testing.T) {
+	server := httptest.NewServer(service.NewRouter())
+	defer server.Close()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+
+	decryptor, err := rsautils.NewRSADecryptorKeyFromString(string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(server.URL)
+
+	bulk, err := c.BulkCreate(&secrets.BulkSecretInput{
+		Data: []*secrets.UnencryptedSecret{
+			{Backend: "none", ClearText: "hello"},
+			{Backend: "none", ClearText: "world"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, err := c.BulkRewrap(bulk, pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"hello", "world"} {
+		clearText, err := Unwrap(decryptor, rewrapped.Data[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(clearText) != expected {
+			t.Errorf("String: %s is not the expected %s", clearText, expected)
+		}
+	}
+
+	if err := c.BulkPurge(bulk); err != nil {
+		t.Error(err)
+	}
+
+	_, err = c.Create(&secrets.UnencryptedSecret{Backend: "unknown", ClearText: "hello"})
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != 400 {
+		t.Errorf("Expected a 400 APIError, got %v", err)
+	}
+}
+
+func TestServerSignature(t *testing.T) {
+	signer, err := signutils.NewRandomSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetServerSigner(signer)
+	defer secrets.SetServerSigner(nil)
+
+	server := httptest.NewServer(service.NewRouter())
+	defer server.Close()
+
+	c := NewClient(server.URL)
+
+	serverKey, err := c.ServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if serverKey.Id != signer.KeyID() {
+		t.Errorf("Server key id %s, expected %s", serverKey.Id, signer.KeyID())
+	}
+
+	pubKey, err := signutils.PublicKeyFromPEM(serverKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := c.Create(&secrets.UnencryptedSecret{Backend: "none", ClearText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	rewrapped, err := c.Rewrap(encrypted, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyServerSignature(pubKey, rewrapped); err != nil {
+		t.Error(err)
+	}
+
+	// Someone holding only the recipient public key can re-encrypt the
+	// payload, but cannot produce a valid server signature for it
+	encData, _ := decodeEnvelope(rewrapped)
+	encData.EncryptedText = encData.EncryptedText + "x"
+	if err := encData.VerifyServerSignature(pubKey); err == nil {
+		t.Error("Expected a tampered envelope to fail verification")
+	}
+}
+
+func TestToken(t *testing.T) {
+	authorization := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"id": "key-1"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL)
+	if _, err := c.ServerKey(); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		t.Errorf("Expected no Authorization header without a token, got %q", authorization)
+	}
+
+	c.Token = "secret-token-1"
+	if _, err := c.ServerKey(); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer secret-token-1" {
+		t.Errorf("Authorization header is %q, expected the bearer token", authorization)
+	}
+}
+
+func TestUnwrapFormats(t *testing.T) {
+	signer, err := signutils.NewRandomSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetServerSigner(signer)
+	defer secrets.SetServerSigner(nil)
+
+	server := httptest.NewServer(service.NewRouter())
+	defer server.Close()
+
+	c := NewClient(server.URL)
+
+	serverKey, err := c.ServerKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPubKey, err := signutils.PublicKeyFromPEM(serverKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	x25519Key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	p256DER, _ := x509.MarshalECPrivateKey(p256Key)
+	x25519DER, _ := x509.MarshalPKCS8PrivateKey(x25519Key)
+
+	keys := []struct {
+		name       string
+		publicKey  interface{}
+		privatePEM *pem.Block
+	}{
+		{name: "rsa", publicKey: &rsaKey.PublicKey, privatePEM: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+		{name: "p256", publicKey: &p256Key.PublicKey, privatePEM: &pem.Block{Type: "EC PRIVATE KEY", Bytes: p256DER}},
+		{name: "x25519", publicKey: x25519Key.PublicKey(), privatePEM: &pem.Block{Type: "PRIVATE KEY", Bytes: x25519DER}},
+	}
+
+	encrypted, err := c.Create(&secrets.UnencryptedSecret{Backend: "none", ClearText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range keys {
+		pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: mustPKIX(t, key.publicKey)}))
+
+		privateKey, err := NewPrivateKeyFromString(string(pem.EncodeToMemory(key.privatePEM)))
+		if err != nil {
+			t.Fatalf("%s: %v", key.name, err)
+		}
+
+		for _, format := range []string{"", "jwe", "jwe-json", "jws-jwe", "jws-jwe-json"} {
+			name := key.name + " " + format
+
+			input := *encrypted
+			input.RewrapFormat = format
+			rewrapped, err := c.Rewrap(&input, pubPEM)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+
+			clearText, err := privateKey.Unwrap(rewrapped)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			} else if string(clearText) != "hello" {
+				t.Errorf("%s: Unwrapped %q, expected hello", name, clearText)
+			}
+
+			err = VerifyServerSignature(serverPubKey, rewrapped)
+			if signed := format != "jwe" && format != "jwe-json"; signed && err != nil {
+				t.Errorf("%s: %v", name, err)
+			} else if !signed && err == nil {
+				t.Errorf("%s: Expected a plain JWE to have no server signature", name)
+			}
+
+			// Unwrap with an RSA decryptor only opens the default envelope
+			// for RSA keys and names what it can not open
+			_, err = Unwrap(rsautils.NewRSADecryptor(rsaKey), rewrapped)
+			if format == "" && key.name == "rsa" {
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), "Unsupported envelope") {
+				t.Errorf("%s: Expected an unsupported envelope error, got %v", name, err)
+			}
+		}
+	}
+
+	// An EC key can not open an envelope for an RSA key
+	rewrapped, err := c.Rewrap(encrypted, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: mustPKIX(t, &rsaKey.PublicKey)})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := NewPrivateKeyFromString(string(pem.EncodeToMemory(keys[1].privatePEM)))
+	if _, err := ecKey.Unwrap(rewrapped); err == nil || !strings.Contains(err.Error(), "Unsupported envelope: key encrypted with PKCS1_OAEP") {
+		t.Errorf("Expected an unsupported envelope error, got %v", err)
+	}
+}
+
+func mustPKIX(t *testing.T, publicKey interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestLogsRedacted(t *testing.T) {
+	const masterKey = "0123456789abcdef0123456789abcdef"
+	const clearText = "do-not-log-this-secret"
+
+	keyPath, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	if err := ioutil.WriteFile(path.Join(keyPath, "testing"), []byte("# format=raw\n"+masterKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := backends.NewConfig()
+	config.Set("localkey", "keyPath", keyPath)
+	backends.SetBackendConfigs(config)
+	defer backends.SetBackendConfigs(backends.NewConfig())
+
+	logs := &bytes.Buffer{}
+	logrus.SetOutput(logs)
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetOutput(os.Stderr)
+	defer logrus.SetLevel(logrus.InfoLevel)
+
+	server := httptest.NewServer(service.NewRouter())
+	defer server.Close()
+
+	c := NewClient(server.URL)
+
+	encrypted, err := c.Create(&secrets.UnencryptedSecret{Backend: "localkey", KeyName: "testing", ClearText: clearText})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	rewrapped, err := c.Rewrap(encrypted, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed requests log errors too, which must not carry the secret either
+	tampered := *encrypted
+	tampered.Signature = base64.StdEncoding.EncodeToString([]byte("0123456789ab:not a valid signature"))
+	if _, err := c.Rewrap(&tampered, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))); err == nil {
+		t.Error("Expected a bad signature to fail the rewrap")
+	}
+
+	if !strings.Contains(logs.String(), "level=debug") {
+		t.Fatal("Expected debug logs to be captured")
+	}
+
+	sensitive := map[string]string{
+		"master key":        masterKey,
+		"hex master key":    hex.EncodeToString([]byte(masterKey)),
+		"base64 master key": base64.StdEncoding.EncodeToString([]byte(masterKey)),
+		"clear text":        clearText,
+		"cipher text":       encrypted.CipherText,
+		"signature":         encrypted.Signature,
+		"rewrap text":       rewrapped.RewrapText,
+	}
+
+	for name, value := range sensitive {
+		if strings.Contains(logs.String(), value) {
+			t.Errorf("Logs contain the %s", name)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/ecutils"
+	"github.com/rancher/secrets-api/pkg/jwe"
+	"github.com/rancher/secrets-api/pkg/jws"
+	"github.com/rancher/secrets-api/pkg/rsautils"
+	"github.com/rancher/secrets-api/secrets"
+)
+
+// Formats of RewrapText, see the rewrapFormat of a rewrap request
+const (
+	envelopeFormat = "envelope"
+	jweFormat      = "jwe"
+	jwsFormat      = "jws"
+)
+
+// PrivateKey is the private half of a rewrap key. RSA keys open envelopes
+// encrypted with PKCS1_OAEP, EC keys open ECDH-ES envelopes, and both open
+// the JWE formats.
+type PrivateKey struct {
+	key       crypto.PrivateKey
+	rsa       rsautils.Decryptor
+	ec        ecutils.Decryptor
+	algorithm string
+}
+
+// NewPrivateKeyFromFile reads a PEM private key, see NewPrivateKeyFromString
+func NewPrivateKeyFromFile(privateKeyPath string) (*PrivateKey, error) {
+	keyData, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPrivateKeyFromString(string(keyData))
+}
+
+// NewPrivateKeyFromString accepts a PEM "RSA PRIVATE KEY" (PKCS#1), "EC
+// PRIVATE KEY" (SEC 1) or "PRIVATE KEY" (PKCS#8) block holding an RSA, EC or
+// Ed25519 key.
+func NewPrivateKeyFromString(privateKey string) (*PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("Could not decode private key. Is it PEM format?")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := parsed.(*rsa.PrivateKey); ok {
+		return &PrivateKey{key: key, rsa: rsautils.NewRSADecryptor(key), algorithm: "PKCS1_OAEP"}, nil
+	}
+
+	decryptor, err := ecutils.NewECDecryptor(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrivateKey{key: parsed, ec: decryptor, algorithm: "ECDH-ES"}, nil
+}
+
+// Unwrap returns the clear text of a secret rewrapped for the public half
+// of the key, in any rewrap format. The server signature of the jws-jwe
+// formats is not checked, see VerifyServerSignature.
+func (k *PrivateKey) Unwrap(secret *secrets.RewrappedSecret) ([]byte, error) {
+	switch rewrapFormat(secret.RewrapText) {
+	case jweFormat:
+		return unwrapJWE(k.key, secret.RewrapText)
+	case jwsFormat:
+		signed, err := jws.Parse(secret.RewrapText)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := base64.RawURLEncoding.DecodeString(signed.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return unwrapJWE(k.key, string(payload))
+	}
+
+	encData, err := decodeEnvelope(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyBytes []byte
+	algorithm := encData.EncryptedKey.EncryptionAlgorithm
+	switch {
+	case k.rsa != nil && isRSAAlgorithm(algorithm):
+		keyBytes, err = k.rsa.Decrypt(encData.EncryptedKey.EncryptedText)
+	case k.ec != nil && strings.HasPrefix(algorithm, "ECDH-ES-"):
+		keyBytes, err = k.ec.Decrypt(encData.EncryptedKey.EphemeralPublicKey, encData.EncryptedKey.EncryptedText)
+	default:
+		return nil, fmt.Errorf("Unsupported envelope: key encrypted with %s, the private key is for %s", algorithm, k.algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return openEnvelope(encData, keyBytes)
+}
+
+// Unwrap returns the clear text of a secret rewrapped for the decryptor's
+// public key. RewrapText is base64 encoded EncryptedData JSON whose
+// EncryptedText decrypts to the base64 encoded secret. Only envelopes for
+// RSA keys are supported, PrivateKey also opens EC envelopes and JWEs.
+func Unwrap(decryptor rsautils.Decryptor, secret *secrets.RewrappedSecret) ([]byte, error) {
+	if format := rewrapFormat(secret.RewrapText); format != envelopeFormat {
+		return nil, fmt.Errorf("Unsupported envelope: secret was rewrapped as a %s, unwrap it with a PrivateKey", strings.ToUpper(format))
+	}
+
+	encData, err := decodeEnvelope(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if algorithm := encData.EncryptedKey.EncryptionAlgorithm; !isRSAAlgorithm(algorithm) {
+		return nil, fmt.Errorf("Unsupported envelope: key encrypted with %s, unwrap it with a PrivateKey", algorithm)
+	}
+
+	keyBytes, err := decryptor.Decrypt(encData.EncryptedKey.EncryptedText)
+	if err != nil {
+		return nil, err
+	}
+
+	return openEnvelope(encData, keyBytes)
+}
+
+// VerifyServerSignature proves a rewrapped secret was created by the server
+// holding the private half of serverKey, see Client.ServerKey. Plain JWEs
+// carry no server signature, request the jws-jwe formats instead.
+func VerifyServerSignature(serverKey crypto.PublicKey, secret *secrets.RewrappedSecret) error {
+	switch rewrapFormat(secret.RewrapText) {
+	case jweFormat:
+		return errors.New("JWE secrets are not signed by the server, rewrap with the jws-jwe format")
+	case jwsFormat:
+		signed, err := jws.Parse(secret.RewrapText)
+		if err != nil {
+			return err
+		}
+
+		_, err = signed.Verify(serverKey)
+		return err
+	}
+
+	encData, err := decodeEnvelope(secret)
+	if err != nil {
+		return err
+	}
+
+	return encData.VerifyServerSignature(serverKey)
+}
+
+// openEnvelope checks and decrypts the envelope with the decrypted AES key
+func openEnvelope(encData *secrets.EncryptedData, keyBytes []byte) ([]byte, error) {
+	aesKey := aesutils.NewAESKeyFromBytes(keyBytes)
+
+	match, err := aesutils.VerifySignature(aesKey, encData.Signature, encData.EncryptedText)
+	if err != nil {
+		return nil, err
+	}
+
+	if !match {
+		return nil, errors.New("Signatures did not match")
+	}
+
+	clearText, err := aesutils.GetClearText(aesKey, encData.EncryptedText)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(clearText)
+}
+
+// unwrapJWE returns the JWE payload, which unlike the default envelope is
+// the secret itself
+func unwrapJWE(privateKey crypto.PrivateKey, serialized string) ([]byte, error) {
+	encrypted, err := jwe.Parse(serialized)
+	if err != nil {
+		return nil, err
+	}
+
+	return encrypted.Decrypt(privateKey)
+}
+
+// isRSAAlgorithm allows envelopes from before the key algorithm was recorded
+func isRSAAlgorithm(algorithm string) bool {
+	return algorithm == "" || algorithm == "PKCS1_OAEP"
+}
+
+// rewrapFormat tells the default base64 envelope apart from the compact and
+// flattened JSON serializations of a JWE or JWS. Base64 has no dots or braces.
+func rewrapFormat(rewrapText string) string {
+	rewrapText = strings.TrimSpace(rewrapText)
+
+	if strings.HasPrefix(rewrapText, "{") {
+		members := map[string]json.RawMessage{}
+		json.Unmarshal([]byte(rewrapText), &members)
+		if _, ok := members["ciphertext"]; ok {
+			return jweFormat
+		}
+		if _, ok := members["signature"]; ok {
+			return jwsFormat
+		}
+		return envelopeFormat
+	}
+
+	switch strings.Count(rewrapText, ".") {
+	case 4:
+		return jweFormat
+	case 2:
+		return jwsFormat
+	}
+
+	return envelopeFormat
+}
+
+func decodeEnvelope(secret *secrets.RewrappedSecret) (*secrets.EncryptedData, error) {
+	encDataJSON, err := base64.StdEncoding.DecodeString(secret.RewrapText)
+	if err != nil {
+		return nil, err
+	}
+
+	encData := &secrets.EncryptedData{}
+	return encData, json.Unmarshal(encDataJSON, encData)
+}
@@ -0,0 +1,50 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rancher/secrets-api/audit"
+	"github.com/urfave/cli"
+)
+
+func AuditVerifyCommand() cli.Command {
+	return cli.Command{
+		Name:        "audit-verify",
+		Usage:       "Check the hash chain of an audit log written with --audit-hash-chain",
+		ArgsUsage:   "AUDIT_LOG...",
+		Description: "Rotated files continue the chain of the file before them, give them oldest first.",
+		Action:      verifyAuditLog,
+	}
+}
+
+func verifyAuditLog(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("An audit log file is required")
+	}
+
+	readers := []io.Reader{}
+	for _, logPath := range c.Args() {
+		file, err := os.Open(logPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		readers = append(readers, file)
+	}
+
+	chained, err := audit.Verify(io.MultiReader(readers...))
+	if err != nil {
+		return err
+	}
+
+	if chained == 0 {
+		return errors.New("No chained lines found")
+	}
+
+	fmt.Printf("%d chained lines verified\n", chained)
+	return nil
+}
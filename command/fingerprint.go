@@ -0,0 +1,41 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rancher/secrets-api/pkg/keyutils"
+	"github.com/urfave/cli"
+)
+
+func FingerprintCommand() cli.Command {
+	return cli.Command{
+		Name:      "fingerprint",
+		Usage:     "Print the fingerprint of a rewrap public key, as used in policy rewrapKeys",
+		ArgsUsage: "[KEY_FILE]",
+		Action:    printFingerprint,
+	}
+}
+
+func printFingerprint(c *cli.Context) error {
+	var key []byte
+	var err error
+
+	if c.NArg() > 0 {
+		key, err = ioutil.ReadFile(c.Args().First())
+	} else {
+		key, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := keyutils.Fingerprint(string(key))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(fingerprint)
+	return nil
+}
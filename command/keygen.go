@@ -0,0 +1,125 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/urfave/cli"
+)
+
+func KeygenCommand() cli.Command {
+	return cli.Command{
+		Name:  "keygen",
+		Usage: "Generate a random AES key for the localkey backend",
+		Description: "Writes NAME under --enc-key-path with mode 0600 and refuses to overwrite it.\n" +
+			"   If NAME is a directory of versioned keys the next version is written instead.",
+		Action: generateKey,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "enc-key-path",
+				Usage:  "Directory holding localkey encryption keys",
+				EnvVar: "ENC_KEY_PATH",
+			},
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "Key name, as used for keyName in requests",
+			},
+			cli.IntFlag{
+				Name:  "bytes",
+				Usage: "Key length in bytes: 16, 24 or 32",
+				Value: 32,
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "Key file format: raw, hex or base64",
+				Value: aesutils.KeyFormatRaw,
+			},
+		},
+	}
+}
+
+func generateKey(c *cli.Context) error {
+	keyPath := c.String("enc-key-path")
+	name := c.String("name")
+
+	if keyPath == "" || name == "" {
+		return errors.New("--enc-key-path and --name are required")
+	}
+
+	if path.Base(name) != name || name == "." || name == ".." {
+		return fmt.Errorf("Invalid key name: %s", name)
+	}
+
+	key, err := aesutils.NewRandomAESKey(c.Int("bytes"))
+	if err != nil {
+		return err
+	}
+
+	keyBytes, err := key.Key()
+	if err != nil {
+		return err
+	}
+
+	keyData, err := aesutils.FormatKeyFile(keyBytes, c.String("format"))
+	if err != nil {
+		return err
+	}
+
+	keyFile, err := nextKeyFile(path.Join(keyPath, name))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(keyData); err != nil {
+		file.Close()
+		os.Remove(keyFile)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	fmt.Println(keyFile)
+	return nil
+}
+
+// nextKeyFile returns keyFile itself, or the next version file when keyFile
+// is a directory of versioned keys
+func nextKeyFile(keyFile string) (string, error) {
+	info, err := os.Stat(keyFile)
+	if os.IsNotExist(err) {
+		return keyFile, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("Key %s already exists, refusing to overwrite", keyFile)
+	}
+
+	files, err := ioutil.ReadDir(keyFile)
+	if err != nil {
+		return "", err
+	}
+
+	latest := 0
+	for _, file := range files {
+		if version, err := strconv.Atoi(file.Name()); err == nil && version > latest {
+			latest = version
+		}
+	}
+
+	return path.Join(keyFile, strconv.Itoa(latest+1)), nil
+}
@@ -0,0 +1,147 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/urfave/cli"
+)
+
+func runKeygen(args ...string) error {
+	app := cli.NewApp()
+	app.Commands = []cli.Command{KeygenCommand()}
+	return app.Run(append([]string{"secrets-api", "keygen"}, args...))
+}
+
+func TestKeygenCommand(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "keygen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	if err := ioutil.WriteFile(filepath.Join(keyPath, "existing"), []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory of versioned keys, with a file that is not a version
+	versioned := filepath.Join(keyPath, "versioned")
+	os.Mkdir(versioned, 0700)
+	for _, name := range []string{"1", "2", "notes"} {
+		ioutil.WriteFile(filepath.Join(versioned, name), []byte("x"), 0600)
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		keyFile   string
+		keyLength int
+		errorText string
+	}{
+		{
+			name:      "default",
+			args:      []string{"--name", "default"},
+			keyFile:   "default",
+			keyLength: 32,
+		},
+		{
+			name:      "16 bytes hex",
+			args:      []string{"--name", "short", "--bytes", "16", "--format", "hex"},
+			keyFile:   "short",
+			keyLength: 16,
+		},
+		{
+			name:      "next version",
+			args:      []string{"--name", "versioned", "--bytes", "24"},
+			keyFile:   filepath.Join("versioned", "3"),
+			keyLength: 24,
+		},
+		{
+			name:      "existing key",
+			args:      []string{"--name", "existing"},
+			errorText: "refusing to overwrite",
+		},
+		{
+			name:      "invalid length",
+			args:      []string{"--name", "invalid", "--bytes", "20"},
+			errorText: "Invalid AES key length 20",
+		},
+		{
+			name:      "zero length",
+			args:      []string{"--name", "invalid", "--bytes", "0"},
+			errorText: "Invalid AES key length 0",
+		},
+		{
+			name:      "negative length",
+			args:      []string{"--name", "invalid", "--bytes", "-32"},
+			errorText: "Invalid AES key length -32",
+		},
+		{
+			name:      "unknown format",
+			args:      []string{"--name", "invalid", "--format", "pem"},
+			errorText: "Unknown key format",
+		},
+		{
+			name:      "path in name",
+			args:      []string{"--name", "../escaped"},
+			errorText: "Invalid key name",
+		},
+		{
+			name:      "no name",
+			args:      []string{},
+			errorText: "required",
+		},
+	}
+
+	for _, test := range tests {
+		err := runKeygen(append([]string{"--enc-key-path", keyPath}, test.args...)...)
+
+		if test.errorText != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errorText) {
+				t.Errorf("%s: Expected an error containing %q, got %v", test.name, test.errorText, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		keyFile := filepath.Join(keyPath, test.keyFile)
+		info, err := os.Stat(keyFile)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s: Key file mode is %o, expected 0600", test.name, info.Mode().Perm())
+		}
+
+		key, err := aesutils.NewAESKeyFromFile(keyFile)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if keyBytes, _ := key.Key(); len(keyBytes) != test.keyLength {
+			t.Errorf("%s: Key is %d bytes, expected %d", test.name, len(keyBytes), test.keyLength)
+		}
+	}
+
+	if data, _ := ioutil.ReadFile(filepath.Join(keyPath, "existing")); string(data) != "keep me" {
+		t.Errorf("Existing key was overwritten with %q", data)
+	}
+
+	if _, err := os.Stat(filepath.Join(keyPath, "invalid")); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file for invalid options: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(keyPath, "..", "escaped")); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file outside the key path: %v", err)
+	}
+}
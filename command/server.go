@@ -1,7 +1,22 @@
 package command
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/secrets-api/audit"
 	"github.com/rancher/secrets-api/backends"
+	"github.com/rancher/secrets-api/backends/vault"
+	"github.com/rancher/secrets-api/pkg/keyutils"
+	"github.com/rancher/secrets-api/pkg/signutils"
+	"github.com/rancher/secrets-api/policy"
+	"github.com/rancher/secrets-api/secrets"
 	"github.com/rancher/secrets-api/service"
 	"github.com/urfave/cli"
 )
@@ -24,15 +39,190 @@ func ServerCommand() cli.Command {
 			},
 			cli.StringFlag{
 				Name:   "vault-token",
-				Usage:  "URL For Vault server with Transit backend enabled",
+				Usage:  "Static Vault token, see the AppRole and Kubernetes flags to log in instead",
 				EnvVar: "VAULT_TOKEN",
 			},
+			cli.StringFlag{
+				Name:   "vault-approle-role-id-file",
+				Usage:  "File holding the AppRole role_id, logs in to Vault with AppRole instead of a token",
+				EnvVar: "VAULT_APPROLE_ROLE_ID_FILE",
+			},
+			cli.StringFlag{
+				Name:   "vault-approle-secret-id-file",
+				Usage:  "File holding the AppRole secret_id",
+				EnvVar: "VAULT_APPROLE_SECRET_ID_FILE",
+			},
+			cli.StringFlag{
+				Name:   "vault-approle-mount",
+				Usage:  "Mount path of the Vault AppRole auth method",
+				Value:  "approle",
+				EnvVar: "VAULT_APPROLE_MOUNT",
+			},
+			cli.StringFlag{
+				Name:   "vault-kubernetes-role",
+				Usage:  "Vault role to log in as with the pod's service account token",
+				EnvVar: "VAULT_KUBERNETES_ROLE",
+			},
+			cli.StringFlag{
+				Name:   "vault-kubernetes-jwt-file",
+				Usage:  "Service account token used for the Vault Kubernetes login",
+				Value:  vault.DefaultKubernetesJWTFile,
+				EnvVar: "VAULT_KUBERNETES_JWT_FILE",
+			},
+			cli.StringFlag{
+				Name:   "vault-kubernetes-mount",
+				Usage:  "Mount path of the Vault Kubernetes auth method",
+				Value:  "kubernetes",
+				EnvVar: "VAULT_KUBERNETES_MOUNT",
+			},
+			cli.StringFlag{
+				Name:   "kms-region",
+				Usage:  "AWS region of the KMS service, enables the kms backend",
+				EnvVar: "KMS_REGION",
+			},
+			cli.StringFlag{
+				Name:   "kms-endpoint",
+				Usage:  "Override the KMS endpoint URL, e.g. for a local KMS emulator",
+				EnvVar: "KMS_ENDPOINT",
+			},
+			cli.StringFlag{
+				Name:   "kms-signing-key",
+				Usage:  "KMS key id or alias used to sign secrets, required with --kms-region",
+				EnvVar: "KMS_SIGNING_KEY",
+			},
+			cli.StringFlag{
+				Name:   "kms-signing-algorithm",
+				Usage:  "KMS MAC (HMAC_*) or signing algorithm for the signing key",
+				Value:  "HMAC_SHA_256",
+				EnvVar: "KMS_SIGNING_ALGORITHM",
+			},
+			cli.StringFlag{
+				Name:   "pkcs11-module",
+				Usage:  "PKCS#11 module library, enables the pkcs11 backend (requires the pkcs11 build tag)",
+				EnvVar: "PKCS11_MODULE",
+			},
+			cli.IntFlag{
+				Name:   "pkcs11-slot",
+				Usage:  "PKCS#11 token slot id",
+				EnvVar: "PKCS11_SLOT",
+			},
+			cli.StringFlag{
+				Name:   "pkcs11-pin",
+				Usage:  "PKCS#11 user PIN",
+				EnvVar: "PKCS11_PIN",
+			},
+			cli.StringFlag{
+				Name:   "pkcs11-key-label",
+				Usage:  "Label of the AES master key used when a secret has no keyName",
+				EnvVar: "PKCS11_KEY_LABEL",
+			},
+			cli.StringFlag{
+				Name:   "pkcs11-hmac-key-label",
+				Usage:  "Label of the secret key used to sign secrets",
+				EnvVar: "PKCS11_HMAC_KEY_LABEL",
+			},
+			cli.StringFlag{
+				Name:   "server-key",
+				Usage:  "PEM Ed25519 or RSA private key rewrap envelopes are signed with, a key is generated per process if unset",
+				EnvVar: "SECRETS_API_SERVER_KEY",
+			},
+			cli.StringFlag{
+				Name:   "rewrap-ca-bundle",
+				Usage:  "PEM CA bundle that certificates given as rewrap keys must chain to",
+				EnvVar: "REWRAP_CA_BUNDLE",
+			},
+			cli.StringSliceFlag{
+				Name:   "backend-config",
+				Usage:  "Backend setting as backend.key=value, may be repeated",
+				EnvVar: "SECRETS_API_BACKEND_CONFIG",
+			},
 			cli.StringFlag{
 				Name:   "listen-address",
-				Usage:  "Address to listen on",
+				Usage:  "Address to listen on, host:port or unix:///path/to/socket",
 				Value:  "127.0.0.1:8181",
 				EnvVar: "SECRETS_API_LISTEN_ADDRESS",
 			},
+			cli.StringFlag{
+				Name:   "socket-mode",
+				Usage:  "Permissions of the unix socket, in octal",
+				Value:  "0660",
+				EnvVar: "SECRETS_API_SOCKET_MODE",
+			},
+			cli.StringFlag{
+				Name:   "socket-owner",
+				Usage:  "Owner of the unix socket as user[:group]",
+				EnvVar: "SECRETS_API_SOCKET_OWNER",
+			},
+			cli.StringFlag{
+				Name:   "socket-create-users",
+				Usage:  "Comma separated local users or uids allowed to create and reencrypt secrets over the unix socket",
+				EnvVar: "SECRETS_API_SOCKET_CREATE_USERS",
+			},
+			cli.StringFlag{
+				Name:   "socket-rewrap-users",
+				Usage:  "Comma separated local users or uids allowed to rewrap and migrate secrets over the unix socket",
+				EnvVar: "SECRETS_API_SOCKET_REWRAP_USERS",
+			},
+			cli.StringFlag{
+				Name:   "tls-cert",
+				Usage:  "PEM certificate to serve HTTPS with, reloaded when the file changes",
+				EnvVar: "SECRETS_API_TLS_CERT",
+			},
+			cli.StringFlag{
+				Name:   "tls-key",
+				Usage:  "PEM private key of --tls-cert",
+				EnvVar: "SECRETS_API_TLS_KEY",
+			},
+			cli.StringFlag{
+				Name:   "tls-client-ca",
+				Usage:  "PEM CA bundle; when set clients must present a certificate signed by it",
+				EnvVar: "SECRETS_API_TLS_CLIENT_CA",
+			},
+			cli.StringFlag{
+				Name:   "auth-token-file",
+				Usage:  "File of \"<token> <name>\" lines accepted as bearer tokens",
+				EnvVar: "SECRETS_API_AUTH_TOKEN_FILE",
+			},
+			cli.StringFlag{
+				Name:   "auth-jwks-file",
+				Usage:  "JWKS file whose keys bearer JWTs must be signed with",
+				EnvVar: "SECRETS_API_AUTH_JWKS_FILE",
+			},
+			cli.StringFlag{
+				Name:   "auth-jwt-issuer",
+				Usage:  "Required iss claim of bearer JWTs",
+				EnvVar: "SECRETS_API_AUTH_JWT_ISSUER",
+			},
+			cli.StringFlag{
+				Name:   "auth-jwt-audience",
+				Usage:  "Required aud claim of bearer JWTs",
+				EnvVar: "SECRETS_API_AUTH_JWT_AUDIENCE",
+			},
+			cli.StringFlag{
+				Name:   "auth-client-subjects",
+				Usage:  "Comma separated client certificate names to accept as cn:, dns:, email: or uri:<name>, * for any, requires --tls-client-ca",
+				EnvVar: "SECRETS_API_AUTH_CLIENT_SUBJECTS",
+			},
+			cli.BoolFlag{
+				Name:   "auth-socket-peers",
+				Usage:  "Authenticate unix socket clients as their local uid",
+				EnvVar: "SECRETS_API_AUTH_SOCKET_PEERS",
+			},
+			cli.StringFlag{
+				Name:   "policy-file",
+				Usage:  "JSON or YAML policy of which callers may use which keys, reloaded on SIGHUP",
+				EnvVar: "SECRETS_API_POLICY_FILE",
+			},
+			cli.StringFlag{
+				Name:   "audit-log",
+				Usage:  "File to append a JSON line to for every operation on secrets, \"syslog\" or \"-\" for stdout",
+				EnvVar: "SECRETS_API_AUDIT_LOG",
+			},
+			cli.BoolFlag{
+				Name:   "audit-hash-chain",
+				Usage:  "Chain audit lines by hash so changes to the log can be detected with audit-verify",
+				EnvVar: "SECRETS_API_AUDIT_HASH_CHAIN",
+			},
 		},
 	}
 }
@@ -40,11 +230,203 @@ func ServerCommand() cli.Command {
 func startServer(c *cli.Context) error {
 	backendConfig := backends.NewConfig()
 
-	backendConfig.EncryptionKeyPath = c.String("enc-key-path")
-	backendConfig.VaultURL = c.String("vault-url")
-	backendConfig.VaultToken = c.String("vault-token")
+	backendConfig.Set("localkey", "keyPath", c.String("enc-key-path"))
+	backendConfig.Set("vault", "url", c.String("vault-url"))
+	backendConfig.Set("vault", "token", c.String("vault-token"))
+
+	if c.String("vault-approle-role-id-file") != "" {
+		backendConfig.Set("vault", "approleRoleIdFile", c.String("vault-approle-role-id-file"))
+		backendConfig.Set("vault", "approleSecretIdFile", c.String("vault-approle-secret-id-file"))
+		backendConfig.Set("vault", "approleMount", c.String("vault-approle-mount"))
+	}
+
+	if c.String("vault-kubernetes-role") != "" {
+		backendConfig.Set("vault", "kubernetesRole", c.String("vault-kubernetes-role"))
+		backendConfig.Set("vault", "kubernetesJwtFile", c.String("vault-kubernetes-jwt-file"))
+		backendConfig.Set("vault", "kubernetesMount", c.String("vault-kubernetes-mount"))
+	}
+
+	if c.String("kms-region") != "" {
+		backendConfig.Set("kms", "region", c.String("kms-region"))
+		backendConfig.Set("kms", "endpoint", c.String("kms-endpoint"))
+		backendConfig.Set("kms", "signingKey", c.String("kms-signing-key"))
+		backendConfig.Set("kms", "signingAlgorithm", c.String("kms-signing-algorithm"))
+	}
+
+	if c.String("pkcs11-module") != "" {
+		backendConfig.Set("pkcs11", "module", c.String("pkcs11-module"))
+		backendConfig.Set("pkcs11", "slot", strconv.Itoa(c.Int("pkcs11-slot")))
+		backendConfig.Set("pkcs11", "pin", c.String("pkcs11-pin"))
+		backendConfig.Set("pkcs11", "keyLabel", c.String("pkcs11-key-label"))
+		backendConfig.Set("pkcs11", "hmacKeyLabel", c.String("pkcs11-hmac-key-label"))
+	}
+
+	for _, option := range c.StringSlice("backend-config") {
+		if err := backendConfig.Parse(option); err != nil {
+			return err
+		}
+	}
 
 	backends.SetBackendConfigs(backendConfig)
 
-	return service.StartServer(c.String("listen-address"))
+	signer, err := loadServerSigner(c.String("server-key"))
+	if err != nil {
+		return err
+	}
+	secrets.SetServerSigner(signer)
+	logrus.Infof("Rewrap envelopes signed with %s server key %s", signer.Algorithm(), signer.KeyID())
+
+	if c.String("rewrap-ca-bundle") != "" {
+		if err := keyutils.LoadCertificateAuthorities(c.String("rewrap-ca-bundle")); err != nil {
+			return err
+		}
+	}
+
+	if err := backends.Validate(); err != nil {
+		return err
+	}
+
+	for _, name := range backends.Registered() {
+		logrus.Infof("Backend %s registered, configured: %t", name, backends.IsConfigured(name))
+	}
+
+	serverConfig := service.Config{
+		ListenAddress: c.String("listen-address"),
+		TLSCert:       c.String("tls-cert"),
+		TLSKey:        c.String("tls-key"),
+		TLSClientCA:   c.String("tls-client-ca"),
+		SocketOwner:   c.String("socket-owner"),
+	}
+
+	socketMode, err := strconv.ParseUint(c.String("socket-mode"), 8, 32)
+	if err != nil {
+		return fmt.Errorf("Invalid --socket-mode: %v", err)
+	}
+	serverConfig.SocketMode = os.FileMode(socketMode)
+
+	if serverConfig.CreateUIDs, err = lookupUIDs(c.String("socket-create-users")); err != nil {
+		return err
+	}
+
+	if serverConfig.RewrapUIDs, err = lookupUIDs(c.String("socket-rewrap-users")); err != nil {
+		return err
+	}
+
+	if serverConfig.Authenticators, err = loadAuthenticators(c); err != nil {
+		return err
+	}
+
+	if len(serverConfig.Authenticators) == 0 {
+		logrus.Warn("No authentication configured, any client that can connect may use the API")
+	}
+
+	if c.String("policy-file") != "" {
+		if err := policy.LoadFile(c.String("policy-file")); err != nil {
+			return err
+		}
+	}
+
+	if c.String("audit-log") != "" {
+		auditLogger, err := audit.Open(c.String("audit-log"), c.Bool("audit-hash-chain"))
+		if err != nil {
+			return err
+		}
+		defer auditLogger.Close()
+
+		audit.SetLogger(auditLogger)
+	}
+
+	go reloadOnHangup()
+
+	return service.StartServer(serverConfig)
+}
+
+// reloadOnHangup re-reads the policy and authentication files on SIGHUP. A
+// file that fails to load leaves its previous contents in force.
+func reloadOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		logrus.Info("SIGHUP received, reloading policy and authentication files")
+
+		if err := policy.Reload(); err != nil {
+			logrus.Errorf("Could not reload policy: %v", err)
+		}
+
+		if err := service.ReloadAuthenticators(); err != nil {
+			logrus.Errorf("Could not reload authentication files: %v", err)
+		}
+	}
+}
+
+func loadAuthenticators(c *cli.Context) ([]service.Authenticator, error) {
+	authenticators := []service.Authenticator{}
+
+	if c.String("auth-client-subjects") != "" {
+		if c.String("tls-client-ca") == "" {
+			return nil, errors.New("--auth-client-subjects requires --tls-client-ca")
+		}
+
+		subjects := []string{}
+		for _, subject := range strings.Split(c.String("auth-client-subjects"), ",") {
+			if subject = strings.TrimSpace(subject); subject != "" {
+				subjects = append(subjects, subject)
+			}
+		}
+		authenticator, err := service.NewClientCertAuthenticator(subjects)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+
+	if c.String("auth-token-file") != "" {
+		authenticator, err := service.NewTokenAuthenticator(c.String("auth-token-file"))
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+
+	if c.String("auth-jwks-file") != "" {
+		authenticator, err := service.NewJWTAuthenticator(c.String("auth-jwks-file"), c.String("auth-jwt-issuer"), c.String("auth-jwt-audience"))
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+
+	if c.Bool("auth-socket-peers") {
+		authenticators = append(authenticators, service.NewPeerAuthenticator())
+	}
+
+	return authenticators, nil
+}
+
+func lookupUIDs(users string) ([]uint32, error) {
+	uids := []uint32{}
+	for _, name := range strings.Split(users, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		uid, err := service.LookupUID(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown user %s: %v", name, err)
+		}
+		uids = append(uids, uid)
+	}
+
+	return uids, nil
+}
+
+func loadServerSigner(keyPath string) (signutils.Signer, error) {
+	if keyPath != "" {
+		return signutils.NewSignerFromFile(keyPath)
+	}
+
+	logrus.Warn("No --server-key given, generating a server key that changes on every restart")
+	return signutils.NewRandomSigner()
 }
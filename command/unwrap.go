@@ -0,0 +1,158 @@
+package command
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rancher/secrets-api/client"
+	"github.com/rancher/secrets-api/pkg/signutils"
+	"github.com/rancher/secrets-api/secrets"
+	"github.com/urfave/cli"
+)
+
+func UnwrapCommand() cli.Command {
+	return cli.Command{
+		Name:   "unwrap",
+		Usage:  "Decrypt a rewrapped secret or bulk rewrapped secret with a private key",
+		Action: unwrapSecrets,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "private-key",
+				Usage: "PEM RSA or EC private key matching the rewrap public key",
+			},
+			cli.StringFlag{
+				Name:  "server-key",
+				Usage: "PEM public key of the server, from /v1-secrets/keys/server, to verify envelopes were signed by it",
+			},
+			cli.StringFlag{
+				Name:  "input",
+				Usage: "File holding the rewrapped secret JSON, defaults to stdin",
+			},
+			cli.StringFlag{
+				Name:  "output-dir",
+				Usage: "Write each secret to its own file, secret-0, secret-1, ..., instead of stdout. Required for bulk input",
+			},
+		},
+	}
+}
+
+func unwrapSecrets(c *cli.Context) error {
+	if c.String("private-key") == "" {
+		return errors.New("--private-key is required")
+	}
+
+	privateKey, err := client.NewPrivateKeyFromFile(c.String("private-key"))
+	if err != nil {
+		return err
+	}
+
+	var serverKey crypto.PublicKey
+	if c.String("server-key") != "" {
+		serverKeyPEM, err := ioutil.ReadFile(c.String("server-key"))
+		if err != nil {
+			return err
+		}
+
+		if serverKey, err = signutils.PublicKeyFromPEM(string(serverKeyPEM)); err != nil {
+			return err
+		}
+	}
+
+	var input []byte
+	if c.String("input") != "" {
+		input, err = ioutil.ReadFile(c.String("input"))
+	} else {
+		input, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	return unwrap(privateKey, serverKey, input, c.String("output-dir"), os.Stdout)
+}
+
+// unwrap decrypts every secret in input. A single secret is written to
+// stdout as is, bulk input needs outputDir since clear text may hold any
+// bytes and can not be split again once concatenated.
+func unwrap(privateKey *client.PrivateKey, serverKey crypto.PublicKey, input []byte, outputDir string, stdout io.Writer) error {
+	rewrapped, err := readRewrappedSecrets(input)
+	if err != nil {
+		return err
+	}
+
+	if outputDir == "" && len(rewrapped) > 1 {
+		return fmt.Errorf("Input holds %d secrets, use --output-dir to write each to its own file", len(rewrapped))
+	}
+
+	clearTexts := make([][]byte, len(rewrapped))
+	for i, secret := range rewrapped {
+		if serverKey != nil {
+			if err := client.VerifyServerSignature(serverKey, secret); err != nil {
+				return fmt.Errorf("Could not verify secret %d: %v", i, err)
+			}
+		}
+
+		if clearTexts[i], err = privateKey.Unwrap(secret); err != nil {
+			return fmt.Errorf("Could not unwrap secret %d: %v", i, err)
+		}
+	}
+
+	if outputDir == "" {
+		_, err := stdout.Write(clearTexts[0])
+		return err
+	}
+
+	// Files are named after the position of the secret in the input and
+	// existing files are never overwritten
+	for i, clearText := range clearTexts {
+		if err := writeSecretFile(filepath.Join(outputDir, "secret-"+strconv.Itoa(i)), clearText); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSecretFile(name string, clearText []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(clearText); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// readRewrappedSecrets accepts either a RewrappedSecret or a BulkRewrappedSecret
+func readRewrappedSecrets(input []byte) ([]*secrets.RewrappedSecret, error) {
+	bulk := &secrets.BulkRewrappedSecret{}
+	if err := json.Unmarshal(input, bulk); err != nil {
+		return nil, err
+	}
+
+	if len(bulk.Data) > 0 {
+		return bulk.Data, nil
+	}
+
+	secret := &secrets.RewrappedSecret{}
+	if err := json.Unmarshal(input, secret); err != nil {
+		return nil, err
+	}
+
+	if secret.RewrapText == "" {
+		return nil, errors.New("Input is not a rewrapped secret")
+	}
+
+	return []*secrets.RewrappedSecret{secret}, nil
+}
@@ -0,0 +1,208 @@
+package command
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rancher/secrets-api/client"
+	"github.com/rancher/secrets-api/secrets"
+	"github.com/urfave/cli"
+)
+
+func rewrapForTest(t *testing.T, pubPEM string, clearTexts ...string) []*secrets.RewrappedSecret {
+	rewrapped := []*secrets.RewrappedSecret{}
+	for _, clearText := range clearTexts {
+		encrypted, err := secrets.NewEncryptedSecret(&secrets.UnencryptedSecret{Backend: "none", ClearText: clearText})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted.RewrapKey = pubPEM
+		secret, err := secrets.NewRewrappedSecret(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewrapped = append(rewrapped, secret)
+	}
+	return rewrapped
+}
+
+func mustJSON(t *testing.T, value interface{}) []byte {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadRewrappedSecrets(t *testing.T) {
+	single := &secrets.RewrappedSecret{RewrapText: "c2luZ2xl"}
+	bulk := &secrets.BulkRewrappedSecret{Data: []*secrets.RewrappedSecret{
+		{RewrapText: "Zmlyc3Q="},
+		{RewrapText: "c2Vjb25k"},
+	}}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+		invalid  bool
+	}{
+		{name: "single", input: string(mustJSON(t, single)), expected: []string{"c2luZ2xl"}},
+		{name: "bulk", input: string(mustJSON(t, bulk)), expected: []string{"Zmlyc3Q=", "c2Vjb25k"}},
+		{name: "empty object", input: "{}", invalid: true},
+		{name: "empty bulk", input: `{"data":[]}`, invalid: true},
+		{name: "not json", input: "rewrapText", invalid: true},
+		{name: "empty", input: "", invalid: true},
+	}
+
+	for _, test := range tests {
+		rewrapped, err := readRewrappedSecrets([]byte(test.input))
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: Expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(rewrapped) != len(test.expected) {
+			t.Errorf("%s: Expected %d secrets, got %d", test.name, len(test.expected), len(rewrapped))
+			continue
+		}
+		for i, secret := range rewrapped {
+			if secret.RewrapText != test.expected[i] {
+				t.Errorf("%s: Secret %d is %s, expected %s", test.name, i, secret.RewrapText, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestUnwrapCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unwrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey := filepath.Join(dir, "private.pem")
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	if err := ioutil.WriteFile(privateKey, privatePEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+
+	// Clear text with newlines would be ambiguous if bulk output were joined
+	clearTexts := []string{"first\nline", "second"}
+	rewrapped := rewrapForTest(t, pubPEM, clearTexts...)
+
+	singleInput := filepath.Join(dir, "single.json")
+	bulkInput := filepath.Join(dir, "bulk.json")
+	ioutil.WriteFile(singleInput, mustJSON(t, rewrapped[0]), 0600)
+	ioutil.WriteFile(bulkInput, mustJSON(t, &secrets.BulkRewrappedSecret{Data: rewrapped}), 0600)
+
+	tests := []struct {
+		name      string
+		args      []string
+		outputDir string
+		expected  []string
+		errorText string
+	}{
+		{
+			name:      "no private key",
+			args:      []string{"--input", singleInput},
+			errorText: "--private-key is required",
+		},
+		{
+			name:      "single to a directory",
+			args:      []string{"--private-key", privateKey, "--input", singleInput},
+			outputDir: "single",
+			expected:  clearTexts[:1],
+		},
+		{
+			name:      "bulk to a directory",
+			args:      []string{"--private-key", privateKey, "--input", bulkInput},
+			outputDir: "bulk",
+			expected:  clearTexts,
+		},
+		{
+			name:      "bulk to stdout",
+			args:      []string{"--private-key", privateKey, "--input", bulkInput},
+			errorText: "use --output-dir",
+		},
+		{
+			name:      "existing files",
+			args:      []string{"--private-key", privateKey, "--input", bulkInput},
+			outputDir: "bulk",
+			errorText: "exists",
+		},
+	}
+
+	for _, test := range tests {
+		args := append([]string{"secrets-api", "unwrap"}, test.args...)
+		outputDir := filepath.Join(dir, test.outputDir)
+		if test.outputDir != "" {
+			os.MkdirAll(outputDir, 0700)
+			args = append(args, "--output-dir", outputDir)
+		}
+
+		app := cli.NewApp()
+		app.Commands = []cli.Command{UnwrapCommand()}
+		err := app.Run(args)
+
+		if test.errorText != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errorText) {
+				t.Errorf("%s: Expected an error containing %q, got %v", test.name, test.errorText, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		for i, expected := range test.expected {
+			clearText, err := ioutil.ReadFile(filepath.Join(outputDir, "secret-"+strconv.Itoa(i)))
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+			if string(clearText) != expected {
+				t.Errorf("%s: Secret %d is %q, expected %q", test.name, i, clearText, expected)
+			}
+		}
+	}
+
+	key, err := client.NewPrivateKeyFromFile(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	if err := unwrap(key, nil, mustJSON(t, rewrapped[0]), "", stdout); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != clearTexts[0] {
+		t.Errorf("Stdout is %q, expected %q", stdout.String(), clearTexts[0])
+	}
+}
@@ -32,7 +32,13 @@ func main() {
 
 	app.Commands = []cli.Command{
 		command.ServerCommand(),
+		command.UnwrapCommand(),
+		command.KeygenCommand(),
+		command.FingerprintCommand(),
+		command.AuditVerifyCommand(),
 	}
 
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
 }
@@ -7,24 +7,38 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 )
 
 type AESSecret struct {
 	Nonce      []byte
 	Algorithm  string
 	CipherText []byte
+	KeyVersion int `json:",omitempty"`
 }
 
 func NewAESKeyFromFile(keyPath string) (AESKey, error) {
 	return newEncryptionKey("file", keyPath), nil
 }
 
+// NewVersionedAESKeyFromFile returns a file backed key that stamps its version
+// into the secrets it encrypts
+func NewVersionedAESKeyFromFile(keyPath string, version int) (AESKey, error) {
+	return newVersionedEncryptionKey(keyPath, version), nil
+}
+
+// NewRandomAESKey returns a key of length bytes, which must be 16, 24 or 32
 func NewRandomAESKey(length int) (AESKey, error) {
-	var err error
-	if k, err := randomNonce(32); err == nil {
-		return &randomKey{key: k}, nil
+	if !validKeyLength(length) {
+		return nil, fmt.Errorf("Invalid AES key length %d, must be 16, 24 or 32 bytes", length)
 	}
-	return nil, err
+
+	k, err := randomNonce(length)
+	if err != nil {
+		return nil, err
+	}
+
+	return &randomKey{key: k}, nil
 }
 
 func NewAESKeyFromBytes(key []byte) AESKey {
@@ -67,6 +81,10 @@ func GetEncryptedText(key AESKey, clearText string, algorithm string) (string, e
 
 	secret.Nonce = nonce
 
+	if versioned, ok := key.(KeyVersioner); ok {
+		secret.KeyVersion = versioned.Version()
+	}
+
 	gcm, err := cipher.NewGCM(cipherBlock)
 	if err != nil {
 		return "", err
@@ -109,6 +127,19 @@ func GetClearText(key AESKey, secretBlob string) (string, error) {
 	return string(plainText), nil
 }
 
+// GetKeyVersion returns the key version an encrypted blob was sealed with.
+// Blobs created before key versioning report version 0
+func GetKeyVersion(secretBlob string) (int, error) {
+	secret := &AESSecret{}
+
+	err := json.Unmarshal([]byte(secretBlob), secret)
+	if err != nil {
+		return 0, err
+	}
+
+	return secret.KeyVersion, nil
+}
+
 func randomNonce(byteLength int) ([]byte, error) {
 	key := make([]byte, byteLength)
 
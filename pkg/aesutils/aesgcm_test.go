@@ -33,3 +33,80 @@ func TestLocalKeyClient(t *testing.T) {
 	}
 
 }
+
+func TestParseKeyFile(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	// key is made of hex characters, like a key written with openssl rand
+	// -hex 16, and must still be read as its 32 raw bytes
+	valid := map[string]string{
+		"legacy raw":       string(key),
+		"legacy newline":   string(key) + "\n",
+		"legacy crlf":      string(key) + "\r\n",
+		"declared hex":     "# format=hex\n3031323334353637383961626364656630313233343536373839616263646566\n",
+		"declared base64":  "# format=base64\n  MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=  \n",
+		"declared raw":     "# format=raw\n" + string(key),
+		"raw newline":      "# format=raw\n" + string(key) + "\n",
+		"formatted raw":    mustFormat(t, key, KeyFormatRaw),
+		"formatted hex":    mustFormat(t, key, KeyFormatHex),
+		"formatted base64": mustFormat(t, key, KeyFormatBase64),
+	}
+
+	for name, data := range valid {
+		parsed, err := ParseKeyFile([]byte(data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if string(parsed) != string(key) {
+			t.Errorf("%s: parsed to %q", name, parsed)
+		}
+	}
+
+	// Legacy raw keys are never decoded, whatever characters they hold
+	for _, raw := range []string{"0123456789abcdef", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3"} {
+		if parsed, err := ParseKeyFile([]byte(raw + "\n")); err != nil || string(parsed) != raw {
+			t.Errorf("Expected the raw key %q, got %q: %v", raw, parsed, err)
+		}
+	}
+
+	// Raw keys with whitespace are kept byte for byte, including a newline
+	// that makes up a valid length, as written by echo
+	echoed := "B374A26A71490437AA024E4FADD5B49\n"
+	spaced := " 123456789abcdef0123456789abcde "
+	kept := map[string]string{
+		echoed: echoed,
+		spaced: spaced,
+		mustFormat(t, []byte(spaced), KeyFormatRaw): spaced,
+	}
+	for data, expected := range kept {
+		if parsed, err := ParseKeyFile([]byte(data)); err != nil || string(parsed) != expected {
+			t.Errorf("Expected the raw key %q, got %q: %v", expected, parsed, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"empty":             "",
+		"short":             "short\n",
+		"undeclared hex":    "3031323334353637383961626364656630313233343536373839616263646566\n",
+		"undeclared base64": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n",
+		"padded raw":        "  " + string(key) + "  \n",
+		"bad hex":           "# format=hex\nnothex",
+		"short base64":      "# format=base64\nMDEy",
+		"unknown format":    "# format=rot13\nabc",
+	}
+	for name, data := range invalid {
+		if _, err := ParseKeyFile([]byte(data)); err == nil {
+			t.Errorf("%s: Expected %q to be rejected", name, data)
+		}
+	}
+}
+
+func mustFormat(t *testing.T, key []byte, format string) string {
+	data, err := FormatKeyFile(key, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
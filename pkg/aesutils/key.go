@@ -1,17 +1,26 @@
 package aesutils
 
 import (
+	"fmt"
 	"io/ioutil"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/rancher/secrets-api/pkg/logutils"
 )
 
+var log = logutils.New("aesutils")
+
 type AESKey interface {
 	Key() ([]byte, error)
 }
 
+// KeyVersioner is implemented by keys that belong to a versioned key set
+type KeyVersioner interface {
+	Version() int
+}
+
 type keyFile struct {
 	pathName string
+	version  int
 }
 
 type randomKey struct {
@@ -24,6 +33,17 @@ func newEncryptionKey(keyType, keyPath string) AESKey {
 	}
 }
 
+func newVersionedEncryptionKey(keyPath string, version int) AESKey {
+	return &keyFile{
+		pathName: keyPath,
+		version:  version,
+	}
+}
+
+func (kf *keyFile) Version() int {
+	return kf.version
+}
+
 func (kf *keyFile) Key() ([]byte, error) {
 	key, err := kf.readPrivateKey()
 	if err != nil {
@@ -39,8 +59,14 @@ func (kf *keyFile) readPrivateKey() ([]byte, error) {
 		return []byte{}, err
 	}
 
-	logrus.Debugf("Key: %s", string(keyData))
-	return keyData, nil
+	key, err := ParseKeyFile(keyData)
+	if err != nil {
+		return []byte{}, fmt.Errorf("Key file %s: %v", kf.pathName, err)
+	}
+
+	log.Debugf("Loaded %d byte key from %s", len(key), kf.pathName)
+
+	return key, nil
 }
 
 func (rk *randomKey) Key() ([]byte, error) {
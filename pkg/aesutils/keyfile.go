@@ -0,0 +1,103 @@
+package aesutils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// Key files without a header hold raw key bytes, as they always have.
+// Encoded keys are declared on a first line of "# format=hex" or
+// "# format=base64", and raw keys may be declared with "# format=raw".
+const keyFormatHeader = "# format="
+
+const (
+	KeyFormatRaw    = "raw"
+	KeyFormatHex    = "hex"
+	KeyFormatBase64 = "base64"
+)
+
+// ParseKeyFile decodes the contents of a key file and checks the key is
+// 16, 24 or 32 bytes long.
+//
+// A file without a header is a legacy raw key and is used as is, or without
+// a trailing newline. Anything else is rejected rather than guessed at: a
+// hex or base64 key read as raw bytes would still have a valid length but
+// would not decrypt the secrets sealed with the file before.
+func ParseKeyFile(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte(keyFormatHeader)) {
+		header := data
+		content := []byte{}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			header, content = data[:i], data[i+1:]
+		}
+
+		format := string(bytes.TrimSpace(header[len(keyFormatHeader):]))
+		return decodeKey(format, content)
+	}
+
+	if key := trimNewline(data); validKeyLength(len(key)) {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("Key file has no format header and is not a 16, 24 or 32 byte raw key (%d bytes), hex and base64 keys need a %q or %q header", len(data), keyFormatHeader+KeyFormatHex, keyFormatHeader+KeyFormatBase64)
+}
+
+// FormatKeyFile encodes a key with a format header
+func FormatKeyFile(key []byte, format string) ([]byte, error) {
+	var content []byte
+
+	switch format {
+	case KeyFormatRaw:
+		// No trailing newline, the key is every byte after the header
+		return append([]byte(keyFormatHeader+format+"\n"), key...), nil
+	case KeyFormatHex:
+		content = []byte(hex.EncodeToString(key))
+	case KeyFormatBase64:
+		content = []byte(base64.StdEncoding.EncodeToString(key))
+	default:
+		return nil, fmt.Errorf("Unknown key format: %s", format)
+	}
+
+	return append([]byte(keyFormatHeader+format+"\n"), append(content, '\n')...), nil
+}
+
+func decodeKey(format string, content []byte) ([]byte, error) {
+	var key []byte
+	var err error
+
+	switch format {
+	case KeyFormatRaw:
+		key = trimNewline(content)
+	case KeyFormatHex:
+		key, err = hex.DecodeString(string(bytes.TrimSpace(content)))
+	case KeyFormatBase64:
+		key, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+	default:
+		return nil, fmt.Errorf("Unknown key format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Key is not valid %s: %v", format, err)
+	}
+
+	if !validKeyLength(len(key)) {
+		return nil, fmt.Errorf("Invalid %s key length %d, must be 16, 24 or 32 bytes", format, len(key))
+	}
+
+	return key, nil
+}
+
+// trimNewline drops a trailing newline added by an editor unless the content
+// already is a valid key. Raw keys may contain whitespace bytes, so nothing
+// else is trimmed.
+func trimNewline(content []byte) []byte {
+	if validKeyLength(len(content)) {
+		return content
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(content, []byte("\n")), []byte("\r"))
+}
+
+func validKeyLength(length int) bool {
+	return length == 16 || length == 24 || length == 32
+}
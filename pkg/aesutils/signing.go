@@ -4,8 +4,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-
-	"github.com/Sirupsen/logrus"
+	"errors"
 )
 
 // Sign implements the interface
@@ -50,18 +49,21 @@ func VerifySignature(aesKey AESKey, signature, message string) (bool, error) {
 		return false, err
 	}
 
-	copy(nonce, byteSignature[:12])
+	if len(byteSignature) < 13 {
+		return false, errors.New("Invalid signature length")
+	}
 
-	logrus.Debugf("Sent Signature in Bytes: %s", byteSignature)
-	logrus.Debugf("Nonce: %s", nonce)
-	logrus.Debugf("Sent Signed Cipher Text: %s", byteSignature[13:])
+	copy(nonce, byteSignature[:12])
 
 	signedMsg, err := sign(key, append(nonce, []byte(":"+message)...))
 	if err != nil {
 		return false, err
 	}
 
-	logrus.Debugf("Generated sig from deciphered text: %s", signedMsg)
+	if !hmac.Equal(byteSignature[13:], signedMsg) {
+		log.Debugf("Signature does not match message")
+		return false, nil
+	}
 
-	return hmac.Equal(byteSignature[13:], signedMsg), nil
+	return true, nil
 }
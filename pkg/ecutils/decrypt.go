@@ -0,0 +1,118 @@
+package ecutils
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+
+	"github.com/rancher/secrets-api/pkg/keyutils"
+)
+
+// Decryptor handles decrypting messages sealed for an EC key
+type Decryptor interface {
+	Decrypt(ephemeralPublicKey, cipherText string) ([]byte, error)
+}
+
+type ecDecryptor struct {
+	key *ecdh.PrivateKey
+}
+
+// NewECDecryptorKeyFromFile returns an EC decryptor
+func NewECDecryptorKeyFromFile(privateKeyPath string) (Decryptor, error) {
+	keyData, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewECDecryptorKeyFromString(string(keyData))
+}
+
+// NewECDecryptorKeyFromString accepts a PEM "PRIVATE KEY" (PKCS#8) or
+// "EC PRIVATE KEY" (SEC 1) block. Ed25519 keys decrypt secrets sealed for
+// their X25519 equivalent.
+func NewECDecryptorKeyFromString(privateKey string) (Decryptor, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("Could not decode private key. Is it PEM format?")
+	}
+
+	var parsed interface{}
+	var err error
+	if block.Type == "EC PRIVATE KEY" {
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewECDecryptor(parsed)
+}
+
+// NewECDecryptor returns an EC decryptor for an *ecdh.PrivateKey,
+// *ecdsa.PrivateKey or ed25519.PrivateKey
+func NewECDecryptor(privateKey interface{}) (Decryptor, error) {
+	switch key := privateKey.(type) {
+	case *ecdh.PrivateKey:
+		return ecDecryptor{key: key}, nil
+	case *ecdsa.PrivateKey:
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return ecDecryptor{key: ecdhKey}, nil
+	case ed25519.PrivateKey:
+		ecdhKey, err := keyutils.Ed25519PrivateKeyToX25519(key)
+		if err != nil {
+			return nil, err
+		}
+		return ecDecryptor{key: ecdhKey}, nil
+	}
+
+	return nil, errors.New("Not an elliptic curve private key")
+}
+
+// Decrypt implements the decryptor interface
+func (e ecDecryptor) Decrypt(ephemeralPublicKey, cipherText string) ([]byte, error) {
+	ephemeralBytes, err := base64.StdEncoding.DecodeString(ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(cipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < nonceSize {
+		return nil, errors.New("Cipher text too short")
+	}
+
+	ephemeral, err := e.key.Curve().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := e.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := algorithmFor(e.key.Curve())
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(algorithm, shared, ephemeral, e.key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
@@ -0,0 +1,110 @@
+// Package ecutils encrypts data for elliptic curve recipients.
+//
+// The scheme is ECIES style: an ephemeral key pair on the recipient's curve
+// is used for ECDH, HKDF-SHA256 derives an AES-256 key from the shared secret
+// (info is the algorithm name, ephemeral public key and recipient public key),
+// and the data is sealed with AES-256-GCM. The cipher text is the 12 byte
+// nonce followed by the GCM output.
+package ecutils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+const nonceSize = 12
+
+// ECPublicKey is a recipient key on P-256, P-384 or X25519
+type ECPublicKey struct {
+	key *ecdh.PublicKey
+}
+
+// NewECPublicKey accepts an *ecdsa.PublicKey or *ecdh.PublicKey
+func NewECPublicKey(pub interface{}) (*ECPublicKey, error) {
+	switch key := pub.(type) {
+	case *ecdh.PublicKey:
+		if _, err := algorithmFor(key.Curve()); err != nil {
+			return nil, err
+		}
+		return &ECPublicKey{key: key}, nil
+	case *ecdsa.PublicKey:
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return NewECPublicKey(ecdhKey)
+	}
+
+	return nil, errors.New("Not an elliptic curve public key")
+}
+
+// Algorithm names the key wrapping scheme for this key's curve
+func (pk *ECPublicKey) Algorithm() string {
+	algorithm, _ := algorithmFor(pk.key.Curve())
+	return algorithm
+}
+
+// Encrypt seals text for the recipient. It returns the base64 ephemeral
+// public key and the base64 cipher text.
+func (pk *ECPublicKey) Encrypt(text string) (string, string, error) {
+	ephemeral, err := pk.key.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	shared, err := ephemeral.ECDH(pk.key)
+	if err != nil {
+		return "", "", err
+	}
+
+	gcm, err := newGCM(pk.Algorithm(), shared, ephemeral.PublicKey(), pk.key)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	cipherText := gcm.Seal(nonce, nonce, []byte(text), nil)
+
+	return base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
+func algorithmFor(curve ecdh.Curve) (string, error) {
+	switch curve {
+	case ecdh.P256():
+		return "ECDH-ES-P256", nil
+	case ecdh.P384():
+		return "ECDH-ES-P384", nil
+	case ecdh.X25519():
+		return "ECDH-ES-X25519", nil
+	}
+
+	return "", errors.New("Unsupported elliptic curve")
+}
+
+func newGCM(algorithm string, shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	info := algorithm + string(ephemeral.Bytes()) + string(recipient.Bytes())
+
+	key, err := hkdf.Key(sha256.New, shared, nil, info, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,148 @@
+package jwe
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/rancher/secrets-api/pkg/keyutils"
+)
+
+func ecdhWrap(hdr *header, recipient *ecdh.PublicKey, cek []byte) ([]byte, error) {
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr.Alg = AlgECDHESA256KW
+	if hdr.Epk, err = publicJWK(ephemeral.PublicKey()); err != nil {
+		return nil, err
+	}
+
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyWrap(concatKDF(shared, AlgECDHESA256KW, 32), cek)
+}
+
+func ecdhUnwrap(hdr *header, privateKey interface{}, encryptedKey []byte) ([]byte, error) {
+	var key *ecdh.PrivateKey
+	switch k := privateKey.(type) {
+	case *ecdh.PrivateKey:
+		key = k
+	case *ecdsa.PrivateKey:
+		var err error
+		if key, err = k.ECDH(); err != nil {
+			return nil, err
+		}
+	case ed25519.PrivateKey:
+		var err error
+		if key, err = keyutils.Ed25519PrivateKeyToX25519(k); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("ECDH-ES+A256KW requires an EC private key")
+	}
+
+	if hdr.Epk == nil {
+		return nil, errors.New("JWE header has no ephemeral public key")
+	}
+
+	ephemeral, err := hdr.Epk.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyUnwrap(concatKDF(shared, AlgECDHESA256KW, 32), encryptedKey)
+}
+
+func publicJWK(pub *ecdh.PublicKey) (*jwk, error) {
+	raw := pub.Bytes()
+
+	switch pub.Curve() {
+	case ecdh.X25519():
+		return &jwk{Kty: "OKP", Crv: "X25519", X: b64.EncodeToString(raw)}, nil
+	case ecdh.P256(), ecdh.P384():
+		crv := "P-256"
+		if pub.Curve() == ecdh.P384() {
+			crv = "P-384"
+		}
+		// Uncompressed point: 0x04 || X || Y
+		size := (len(raw) - 1) / 2
+		return &jwk{
+			Kty: "EC",
+			Crv: crv,
+			X:   b64.EncodeToString(raw[1 : 1+size]),
+			Y:   b64.EncodeToString(raw[1+size:]),
+		}, nil
+	}
+
+	return nil, errors.New("Unsupported elliptic curve")
+}
+
+func (k *jwk) publicKey() (*ecdh.PublicKey, error) {
+	x, err := b64.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.Crv {
+	case "X25519":
+		return ecdh.X25519().NewPublicKey(x)
+	case "P-256", "P-384":
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		curve := ecdh.P256()
+		if k.Crv == "P-384" {
+			curve = ecdh.P384()
+		}
+
+		return curve.NewPublicKey(append(append([]byte{4}, x...), y...))
+	}
+
+	return nil, errors.New("Unsupported JWK curve: " + k.Crv)
+}
+
+// concatKDF is the single step KDF from NIST SP 800-56A as profiled by
+// RFC 7518 section 4.6.2, with empty PartyUInfo and PartyVInfo.
+func concatKDF(shared []byte, algorithm string, keyLen int) []byte {
+	otherInfo := lengthPrefixed([]byte(algorithm))
+	otherInfo = append(otherInfo, lengthPrefixed(nil)...)
+	otherInfo = append(otherInfo, lengthPrefixed(nil)...)
+	otherInfo = append(otherInfo, uint32Bytes(uint32(keyLen*8))...)
+
+	key := []byte{}
+	for counter := uint32(1); len(key) < keyLen; counter++ {
+		hash := sha256.New()
+		hash.Write(uint32Bytes(counter))
+		hash.Write(shared)
+		hash.Write(otherInfo)
+		key = hash.Sum(key)
+	}
+
+	return key[:keyLen]
+}
+
+func lengthPrefixed(data []byte) []byte {
+	return append(uint32Bytes(uint32(len(data))), data...)
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
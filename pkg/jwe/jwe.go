@@ -0,0 +1,217 @@
+// Package jwe produces and opens JSON Web Encryption (RFC 7516) objects
+// with A256GCM content encryption. RSA recipients use RSA-OAEP-256, EC
+// recipients (P-256, P-384, X25519) use ECDH-ES+A256KW.
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	AlgRSAOAEP256   = "RSA-OAEP-256"
+	AlgECDHESA256KW = "ECDH-ES+A256KW"
+	EncA256GCM      = "A256GCM"
+
+	gcmTagSize = 16
+)
+
+var b64 = base64.RawURLEncoding
+
+// JWE holds the base64url encoded parts of an encrypted object
+type JWE struct {
+	Protected    string `json:"protected"`
+	EncryptedKey string `json:"encrypted_key"`
+	IV           string `json:"iv"`
+	Ciphertext   string `json:"ciphertext"`
+	Tag          string `json:"tag"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Epk *jwk   `json:"epk,omitempty"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Encrypt seals payload for the recipient public key using cek, which must
+// be 32 bytes, as the content encryption key.
+func Encrypt(recipient interface{}, cek, payload []byte) (*JWE, error) {
+	if len(cek) != 32 {
+		return nil, errors.New("A256GCM requires a 32 byte content encryption key")
+	}
+
+	hdr := &header{Enc: EncA256GCM}
+	var encryptedKey []byte
+	var err error
+
+	switch key := recipient.(type) {
+	case *rsa.PublicKey:
+		hdr.Alg = AlgRSAOAEP256
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, key, cek, nil)
+	case *ecdsa.PublicKey:
+		var ecdhKey *ecdh.PublicKey
+		if ecdhKey, err = key.ECDH(); err == nil {
+			encryptedKey, err = ecdhWrap(hdr, ecdhKey, cek)
+		}
+	case *ecdh.PublicKey:
+		encryptedKey, err = ecdhWrap(hdr, key, cek)
+	default:
+		err = errors.New("Unsupported JWE recipient key type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hdrJSON, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	jwe := &JWE{
+		Protected:    b64.EncodeToString(hdrJSON),
+		EncryptedKey: b64.EncodeToString(encryptedKey),
+	}
+
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(nil, iv, payload, []byte(jwe.Protected))
+
+	jwe.IV = b64.EncodeToString(iv)
+	jwe.Ciphertext = b64.EncodeToString(sealed[:len(sealed)-gcmTagSize])
+	jwe.Tag = b64.EncodeToString(sealed[len(sealed)-gcmTagSize:])
+
+	return jwe, nil
+}
+
+// Compact returns the compact serialization
+func (j *JWE) Compact() string {
+	return strings.Join([]string{j.Protected, j.EncryptedKey, j.IV, j.Ciphertext, j.Tag}, ".")
+}
+
+// JSON returns the flattened JSON serialization
+func (j *JWE) JSON() (string, error) {
+	data, err := json.Marshal(j)
+	return string(data), err
+}
+
+// Parse reads a JWE in compact or flattened JSON serialization
+func Parse(serialized string) (*JWE, error) {
+	serialized = strings.TrimSpace(serialized)
+
+	if strings.HasPrefix(serialized, "{") {
+		jwe := &JWE{}
+		return jwe, json.Unmarshal([]byte(serialized), jwe)
+	}
+
+	parts := strings.Split(serialized, ".")
+	if len(parts) != 5 {
+		return nil, errors.New("Invalid JWE compact serialization")
+	}
+
+	return &JWE{
+		Protected:    parts[0],
+		EncryptedKey: parts[1],
+		IV:           parts[2],
+		Ciphertext:   parts[3],
+		Tag:          parts[4],
+	}, nil
+}
+
+// Decrypt opens the JWE with an *rsa.PrivateKey, *ecdsa.PrivateKey or
+// *ecdh.PrivateKey
+func (j *JWE) Decrypt(privateKey interface{}) ([]byte, error) {
+	hdrJSON, err := b64.DecodeString(j.Protected)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := &header{}
+	if err := json.Unmarshal(hdrJSON, hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr.Enc != EncA256GCM {
+		return nil, errors.New("Unsupported JWE content encryption: " + hdr.Enc)
+	}
+
+	encryptedKey, err := b64.DecodeString(j.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var cek []byte
+	switch hdr.Alg {
+	case AlgRSAOAEP256:
+		key, ok := privateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RSA-OAEP-256 requires an RSA private key")
+		}
+		cek, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key, encryptedKey, nil)
+	case AlgECDHESA256KW:
+		cek, err = ecdhUnwrap(hdr, privateKey, encryptedKey)
+	default:
+		err = errors.New("Unsupported JWE key management algorithm: " + hdr.Alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := b64.DecodeString(j.IV)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherText, err := b64.DecodeString(j.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := b64.DecodeString(j.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != gcm.NonceSize() {
+		return nil, errors.New("Invalid JWE initialization vector")
+	}
+
+	return gcm.Open(nil, iv, append(cipherText, tag...), []byte(j.Protected))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,92 @@
+package jwe
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"testing"
+)
+
+const secretText = "my secret to keep"
+
+func TestKeyWrap(t *testing.T) {
+	// RFC 3394 section 4.6
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	expected, _ := hex.DecodeString("28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21")
+
+	wrapped, err := keyWrap(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(wrapped, expected) {
+		t.Errorf("Wrapped key %x, expected %x", wrapped, expected)
+	}
+
+	unwrapped, err := keyUnwrap(kek, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, key) {
+		t.Errorf("Unwrapped key %x, expected %x: %v", unwrapped, key, err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cek := make([]byte, 32)
+	rand.Read(cek)
+
+	recipients := []struct {
+		public  interface{}
+		private interface{}
+	}{
+		{&rsaKey.PublicKey, rsaKey},
+		{&ecKey.PublicKey, ecKey},
+		{x25519Key.PublicKey(), x25519Key},
+	}
+
+	for _, recipient := range recipients {
+		jwe, err := Encrypt(recipient.public, cek, []byte(secretText))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jsonJWE, err := jwe.JSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, serialized := range []string{jwe.Compact(), jsonJWE} {
+			parsed, err := Parse(serialized)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := parsed.Decrypt(recipient.private)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(payload) != secretText {
+				t.Errorf("Payload decrypted to '%s' and we expected '%s'", payload, secretText)
+			}
+		}
+	}
+}
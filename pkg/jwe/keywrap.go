@@ -0,0 +1,82 @@
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+var defaultIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// keyWrap implements the AES Key Wrap algorithm from RFC 3394
+func keyWrap(kek, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, errors.New("Key to wrap must be a multiple of 8 bytes")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	r := make([]byte, len(key))
+	copy(r, key)
+
+	a := make([]byte, 8)
+	copy(a, defaultIV)
+
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[i*8:(i+1)*8])
+			block.Encrypt(buf, buf)
+
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^t)
+			copy(r[i*8:], buf[8:])
+		}
+	}
+
+	return append(a, r...), nil
+}
+
+// keyUnwrap reverses keyWrap and checks the integrity value
+func keyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.New("Invalid wrapped key length")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^t)
+			copy(buf[8:], r[i*8:(i+1)*8])
+			block.Decrypt(buf, buf)
+
+			copy(a, buf[:8])
+			copy(r[i*8:], buf[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a, defaultIV) != 1 {
+		return nil, errors.New("Key unwrap integrity check failed")
+	}
+
+	return r, nil
+}
@@ -0,0 +1,144 @@
+// Package jws signs payloads with the server identity key as JSON Web
+// Signatures (RFC 7515) and verifies them. Rewrapped JWE secrets are wrapped
+// in a JWS so consumers can tell they were produced by the server.
+package jws
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rancher/secrets-api/pkg/signutils"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgPS256 = "PS256"
+)
+
+var b64 = base64.RawURLEncoding
+
+// algorithms maps the JWS alg names to the signutils algorithms
+var algorithms = map[string]string{
+	AlgEdDSA: signutils.AlgorithmEd25519,
+	AlgPS256: signutils.AlgorithmPS256,
+}
+
+// JWS holds the base64url encoded parts of a signed object
+type JWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Cty string `json:"cty,omitempty"`
+}
+
+// Sign signs payload, whose media type is contentType, with signer
+func Sign(signer signutils.Signer, payload []byte, contentType string) (*JWS, error) {
+	hdr := &header{Kid: signer.KeyID(), Cty: contentType}
+	for alg, algorithm := range algorithms {
+		if algorithm == signer.Algorithm() {
+			hdr.Alg = alg
+		}
+	}
+	if hdr.Alg == "" {
+		return nil, fmt.Errorf("No JWS algorithm for %s keys", signer.Algorithm())
+	}
+
+	hdrJSON, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	jws := &JWS{
+		Protected: b64.EncodeToString(hdrJSON),
+		Payload:   b64.EncodeToString(payload),
+	}
+
+	signature, err := signer.Sign(jws.signingInput())
+	if err != nil {
+		return nil, err
+	}
+
+	// signutils encodes with standard base64, JWS with base64url
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, err
+	}
+	jws.Signature = b64.EncodeToString(raw)
+
+	return jws, nil
+}
+
+// Compact returns the compact serialization
+func (j *JWS) Compact() string {
+	return strings.Join([]string{j.Protected, j.Payload, j.Signature}, ".")
+}
+
+// JSON returns the flattened JSON serialization
+func (j *JWS) JSON() (string, error) {
+	data, err := json.Marshal(j)
+	return string(data), err
+}
+
+// Parse reads a JWS in compact or flattened JSON serialization
+func Parse(serialized string) (*JWS, error) {
+	serialized = strings.TrimSpace(serialized)
+
+	if strings.HasPrefix(serialized, "{") {
+		jws := &JWS{}
+		return jws, json.Unmarshal([]byte(serialized), jws)
+	}
+
+	parts := strings.Split(serialized, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Invalid JWS compact serialization")
+	}
+
+	return &JWS{
+		Protected: parts[0],
+		Payload:   parts[1],
+		Signature: parts[2],
+	}, nil
+}
+
+// Verify checks the signature with the server's public key and returns the
+// payload
+func (j *JWS) Verify(publicKey crypto.PublicKey) ([]byte, error) {
+	hdrJSON, err := b64.DecodeString(j.Protected)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := &header{}
+	if err := json.Unmarshal(hdrJSON, hdr); err != nil {
+		return nil, err
+	}
+
+	algorithm, ok := algorithms[hdr.Alg]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported JWS algorithm: %s", hdr.Alg)
+	}
+
+	signature, err := b64.DecodeString(j.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := signutils.Verify(publicKey, algorithm, j.signingInput(), base64.StdEncoding.EncodeToString(signature)); err != nil {
+		return nil, err
+	}
+
+	return b64.DecodeString(j.Payload)
+}
+
+func (j *JWS) signingInput() []byte {
+	return []byte(j.Protected + "." + j.Payload)
+}
@@ -0,0 +1,314 @@
+// Package jwt verifies compact JWS signed JSON Web Tokens (RFC 7519)
+// against a local JSON Web Key Set. Only asymmetric algorithms are
+// accepted: RS*, PS*, ES* and EdDSA.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/rancher/secrets-api/pkg/keyutils"
+)
+
+var b64 = base64.RawURLEncoding
+
+// DefaultLeeway is the clock skew allowed when checking exp and nbf
+const DefaultLeeway = time.Minute
+
+// Claims holds the registered claims of a verified token
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// audience accepts both the string and the array form of aud
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("Invalid aud claim")
+	}
+	*a = list
+
+	return nil
+}
+
+// VerifyOptions are the checks applied to the claims of a token
+type VerifyOptions struct {
+	// Issuer and Audience are required to match when set
+	Issuer   string
+	Audience string
+	// Leeway defaults to DefaultLeeway
+	Leeway time.Duration
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jsonWebKey holds the fields of a JWK used to pick a key, the key material
+// is parsed by keyutils.ParseJWK
+type jsonWebKey struct {
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+}
+
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet is a set of public keys tokens are verified with
+type KeySet struct {
+	keys []verificationKey
+}
+
+// LoadKeySet reads a JWKS file
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet, err := ParseKeySet(data)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS %s: %v", path, err)
+	}
+
+	return keySet, nil
+}
+
+// ParseKeySet parses a JWKS document. Keys with "use" other than "sig" are
+// skipped.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	doc := struct {
+		Keys []json.RawMessage `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	keySet := &KeySet{}
+	for i, raw := range doc.Keys {
+		jwk := jsonWebKey{}
+		if err := json.Unmarshal(raw, &jwk); err != nil {
+			return nil, fmt.Errorf("Key %d: %v", i, err)
+		}
+
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := keyutils.ParseJWK(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Key %d: %v", i, err)
+		}
+
+		keySet.keys = append(keySet.keys, verificationKey{
+			kid: jwk.Kid,
+			alg: jwk.Alg,
+			key: key,
+		})
+	}
+
+	if len(keySet.keys) == 0 {
+		return nil, errors.New("No signing keys found")
+	}
+
+	return keySet, nil
+}
+
+// Len returns the number of keys in the set
+func (k *KeySet) Len() int {
+	return len(k.keys)
+}
+
+// Verify checks the signature and the time, issuer and audience claims of a
+// compact serialized token
+func (k *KeySet) Verify(token string, opts VerifyOptions) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed token")
+	}
+
+	hdr := &header{}
+	if err := decodeSegment(parts[0], hdr); err != nil {
+		return nil, errors.New("Malformed token header")
+	}
+
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Malformed token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	verified := false
+	for _, key := range k.keys {
+		if hdr.Kid != "" && key.kid != "" && key.kid != hdr.Kid {
+			continue
+		}
+		if key.alg != "" && key.alg != hdr.Alg {
+			continue
+		}
+
+		if verifySignature(hdr.Alg, key.key, signed, signature) == nil {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, errors.New("Invalid token signature")
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, errors.New("Malformed token claims")
+	}
+
+	if err := claims.validate(opts); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (c *Claims) validate(opts VerifyOptions) error {
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+
+	leeway := opts.Leeway
+	if leeway == 0 {
+		leeway = DefaultLeeway
+	}
+
+	if c.ExpiresAt == 0 {
+		return errors.New("Token has no expiry")
+	}
+
+	if now.Add(-leeway).After(time.Unix(c.ExpiresAt, 0)) {
+		return errors.New("Token has expired")
+	}
+
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return errors.New("Token is not valid yet")
+	}
+
+	if opts.Issuer != "" && c.Issuer != opts.Issuer {
+		return errors.New("Token issuer does not match")
+	}
+
+	if opts.Audience != "" {
+		for _, aud := range c.Audience {
+			if aud == opts.Audience {
+				return nil
+			}
+		}
+		return errors.New("Token audience does not match")
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := b64.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func verifySignature(alg string, publicKey crypto.PublicKey, signed, signature []byte) error {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		key, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("Key type does not match algorithm")
+		}
+
+		hash := hashFor(alg[2:])
+		digest := hashed(hash, signed)
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+	case "ES256", "ES384", "ES512":
+		key, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("Key type does not match algorithm")
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("Invalid signature length")
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, hashed(hashFor(alg[2:]), signed), r, s) {
+			return errors.New("Invalid signature")
+		}
+		return nil
+	case "EdDSA":
+		key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("Key type does not match algorithm")
+		}
+
+		if !ed25519.Verify(key, signed, signature) {
+			return errors.New("Invalid signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported token algorithm: %s", alg)
+}
+
+func hashFor(bits string) crypto.Hash {
+	switch bits {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+func hashed(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
@@ -0,0 +1,127 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	hdr, _ := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(hdr) + "." + b64.EncodeToString(body)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *ecdsa.PrivateKey:
+		r, s, signErr := ecdsa.Sign(rand.Reader, k, hashed(crypto.SHA256, []byte(signed)))
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		err = signErr
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hashed(crypto.SHA256, []byte(signed)))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coord := func(v *big.Int) string { return b64.EncodeToString(v.FillBytes(make([]byte, 32))) }
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": %q},
+		{"kty": "EC", "crv": "P-256", "kid": "ec", "alg": "ES256", "x": %q, "y": %q},
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": %q, "e": "AQAB"},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": %q, "e": "AQAB"}
+	]}`,
+		b64.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+		coord(ecKey.X), coord(ecKey.Y),
+		b64.EncodeToString(rsaKey.N.Bytes()),
+		b64.EncodeToString(rsaKey.N.Bytes()))
+
+	keySet, err := ParseKeySet([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keySet.Len() != 3 {
+		t.Errorf("Expected 3 signing keys, got %d", keySet.Len())
+	}
+
+	now := time.Now()
+	valid := map[string]interface{}{
+		"iss": "https://issuer",
+		"sub": "builder",
+		"aud": []string{"secrets-api", "other"},
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	opts := VerifyOptions{Issuer: "https://issuer", Audience: "secrets-api"}
+
+	for _, token := range []string{
+		signToken(t, "EdDSA", "ed", edKey, valid),
+		signToken(t, "ES256", "ec", ecKey, valid),
+		signToken(t, "RS256", "", rsaKey, valid),
+	} {
+		claims, err := keySet.Verify(token, opts)
+		if err != nil {
+			t.Errorf("Valid token rejected: %v", err)
+			continue
+		}
+
+		if claims.Subject != "builder" {
+			t.Errorf("Expected subject builder, got %s", claims.Subject)
+		}
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	expired := map[string]interface{}{"sub": "builder", "exp": now.Add(-time.Hour).Unix()}
+	future := map[string]interface{}{"sub": "builder", "exp": now.Add(2 * time.Hour).Unix(), "nbf": now.Add(time.Hour).Unix()}
+	noExpiry := map[string]interface{}{"sub": "builder", "aud": "secrets-api", "iss": "https://issuer"}
+	wrongAudience := map[string]interface{}{"sub": "builder", "iss": "https://issuer", "aud": "other", "exp": now.Add(time.Hour).Unix()}
+
+	good := signToken(t, "EdDSA", "ed", edKey, valid)
+	parts := strings.Split(good, ".")
+	none := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	for name, token := range map[string]string{
+		"expired":        signToken(t, "EdDSA", "ed", edKey, expired),
+		"not yet valid":  signToken(t, "EdDSA", "ed", edKey, future),
+		"no expiry":      signToken(t, "EdDSA", "ed", edKey, noExpiry),
+		"wrong audience": signToken(t, "EdDSA", "ed", edKey, wrongAudience),
+		"unknown key":    signToken(t, "EdDSA", "", otherKey, valid),
+		"alg mismatch":   signToken(t, "ES256", "ed", edKey, valid),
+		"alg none":       none,
+		"tampered":       parts[0] + "." + b64.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2],
+		"malformed":      "not-a-token",
+	} {
+		opts := opts
+		if name == "expired" || name == "not yet valid" {
+			opts = VerifyOptions{}
+		}
+
+		if _, err := keySet.Verify(token, opts); err == nil {
+			t.Errorf("Expected %s token to be rejected", name)
+		}
+	}
+}
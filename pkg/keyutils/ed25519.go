@@ -0,0 +1,57 @@
+package keyutils
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+)
+
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// Ed25519PublicKeyToX25519 maps an Ed25519 public key to the X25519 key of
+// the same key pair using the birational map u = (1 + y) / (1 - y).
+func Ed25519PublicKeyToX25519(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("Invalid Ed25519 public key")
+	}
+
+	// y is encoded little endian with the sign of x in the top bit
+	yBytes := reverse(pub)
+	yBytes[0] &= 0x7f
+	y := new(big.Int).SetBytes(yBytes)
+
+	one := big.NewInt(1)
+	numerator := new(big.Int).Add(one, y)
+	denominator := new(big.Int).Sub(one, y)
+	denominator.Mod(denominator, curve25519P)
+
+	if denominator.Sign() == 0 {
+		return nil, errors.New("Invalid Ed25519 public key")
+	}
+
+	u := numerator.Mul(numerator, denominator.ModInverse(denominator, curve25519P))
+	u.Mod(u, curve25519P)
+
+	return ecdh.X25519().NewPublicKey(reverse(u.FillBytes(make([]byte, 32))))
+}
+
+// Ed25519PrivateKeyToX25519 derives the X25519 private key matching
+// Ed25519PublicKeyToX25519 from the key's seed.
+func Ed25519PrivateKeyToX25519(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, errors.New("Invalid Ed25519 private key")
+	}
+
+	hash := sha512.Sum512(priv.Seed())
+	return ecdh.X25519().NewPrivateKey(hash[:32])
+}
+
+func reverse(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i := range in {
+		out[i] = in[len(in)-1-i]
+	}
+	return out
+}
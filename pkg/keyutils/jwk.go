@@ -0,0 +1,108 @@
+package keyutils
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWK returns the public key of a JWK JSON object: an *rsa.PublicKey,
+// an *ecdsa.PublicKey for P-256, P-384 and P-521 keys, an *ecdh.PublicKey for
+// X25519 and an ed25519.PublicKey for Ed25519. Unlike ParsePublicKey it does
+// not convert keys for encryption, so the result can verify signatures.
+// Fields other than the key material, such as kid and use, are ignored.
+func ParseJWK(data []byte) (crypto.PublicKey, error) {
+	key := &jwk{}
+	if err := json.Unmarshal(data, key); err != nil {
+		return nil, err
+	}
+
+	switch key.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("Invalid RSA JWK")
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent.Int64()),
+		}, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(key.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported JWK curve: %s", key.Crv)
+		}
+
+		// Rejects points that are not on the curve
+		ecKey, err := ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, errors.New("Invalid EC JWK")
+		}
+
+		return ecKey, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key.Crv {
+		case "X25519":
+			return ecdh.X25519().NewPublicKey(x)
+		case "Ed25519":
+			if len(x) != ed25519.PublicKeySize {
+				return nil, errors.New("Invalid Ed25519 JWK")
+			}
+			return ed25519.PublicKey(x), nil
+		}
+
+		return nil, fmt.Errorf("Unsupported JWK curve: %s", key.Crv)
+	}
+
+	return nil, fmt.Errorf("Unsupported JWK key type: %s", key.Kty)
+}
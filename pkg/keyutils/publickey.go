@@ -0,0 +1,169 @@
+package keyutils
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var certificateAuthorities *x509.CertPool
+
+// LoadCertificateAuthorities reads a PEM bundle of CA certificates. Once
+// loaded, public keys given as X.509 certificates must chain to one of them.
+func LoadCertificateAuthorities(bundlePath string) error {
+	bundle, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("No certificates found in CA bundle: %s", bundlePath)
+	}
+
+	certificateAuthorities = pool
+	return nil
+}
+
+// ParsePublicKey returns the public key held in pKey, which may be
+//
+//	a PEM "PUBLIC KEY" (PKIX), "RSA PUBLIC KEY" (PKCS#1) or "CERTIFICATE" block,
+//	a JWK JSON object,
+//	or an ssh-rsa, ssh-ed25519 or ecdsa-sha2-nistp* authorized key line.
+//
+// The result is an *rsa.PublicKey, *ecdsa.PublicKey or *ecdh.PublicKey.
+// Ed25519 keys are converted to their X25519 equivalent so they can be
+// encrypted to.
+func ParsePublicKey(pKey string) (crypto.PublicKey, error) {
+	pub, err := parsePublicKey(pKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalize(pub)
+}
+
+// Fingerprint returns the hex SHA-256 of the PKIX encoding of the public key
+// held in pKey, in any format ParsePublicKey accepts. The same key has the
+// same fingerprint whatever the format, and Ed25519 keys are fingerprinted
+// before their conversion to X25519.
+func Fingerprint(pKey string) (string, error) {
+	pub, err := parsePublicKey(pKey)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := normalize(pub); err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func parsePublicKey(pKey string) (crypto.PublicKey, error) {
+	pKey = strings.TrimSpace(pKey)
+
+	switch {
+	case strings.HasPrefix(pKey, "{"):
+		return ParseJWK([]byte(pKey))
+	case strings.HasPrefix(pKey, "-----BEGIN"):
+		return parsePEM(pKey)
+	case strings.Contains(pKey, "ssh-") || strings.Contains(pKey, "ecdsa-sha2-"):
+		return parseAuthorizedKey(pKey)
+	}
+
+	return nil, errors.New("Unsupported public key format, expected PEM, JWK or an SSH authorized key")
+}
+
+func parsePEM(pKey string) (crypto.PublicKey, error) {
+	block, rest := pem.Decode([]byte(pKey))
+	if block == nil {
+		return nil, errors.New("Could not decode public key block")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		return parseCertificateChain(block, rest)
+	}
+
+	return nil, fmt.Errorf("Unsupported PEM block type: %s", block.Type)
+}
+
+// parseCertificateChain takes the first certificate as the leaf and any
+// following ones as intermediates
+func parseCertificateChain(leafBlock *pem.Block, rest []byte) (crypto.PublicKey, error) {
+	leaf, err := x509.ParseCertificate(leafBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if certificateAuthorities == nil {
+		return leaf.PublicKey, nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		intermediates.AddCert(cert)
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         certificateAuthorities,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Certificate is not trusted: %v", err)
+	}
+
+	return leaf.PublicKey, nil
+}
+
+func normalize(pub crypto.PublicKey) (crypto.PublicKey, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	case *ecdh.PublicKey:
+		if key.Curve() != ecdh.X25519() && key.Curve() != ecdh.P256() && key.Curve() != ecdh.P384() {
+			return nil, errors.New("Unsupported elliptic curve")
+		}
+		return key, nil
+	case ed25519.PublicKey:
+		return Ed25519PublicKeyToX25519(key)
+	}
+
+	return nil, fmt.Errorf("Unsupported public key type: %T", pub)
+}
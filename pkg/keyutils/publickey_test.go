@@ -0,0 +1,227 @@
+package keyutils
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEd25519ToX25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xPub, err := Ed25519PublicKeyToX25519(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xPriv, err := Ed25519PrivateKeyToX25519(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(xPub.Bytes(), xPriv.PublicKey().Bytes()) {
+		t.Error("Converted Ed25519 public and private keys do not match")
+	}
+}
+
+func TestParsePublicKeyFormats(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64url := base64.RawURLEncoding.EncodeToString
+	ecPoint, _ := ecKey.PublicKey.Bytes()
+
+	keys := map[string]string{
+		"pkcs1": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey),
+		})),
+		"rsa jwk": `{"kty":"RSA","n":"` + b64url(rsaKey.N.Bytes()) + `","e":"AQAB"}`,
+		"ec jwk":  `{"kty":"EC","crv":"P-256","x":"` + b64url(ecPoint[1:33]) + `","y":"` + b64url(ecPoint[33:]) + `"}`,
+		"ed jwk":  `{"kty":"OKP","crv":"Ed25519","x":"` + b64url(edPub) + `"}`,
+		"ssh-rsa": "ssh-rsa " + base64.StdEncoding.EncodeToString(sshWire([]byte("ssh-rsa"),
+			sshMpint(big.NewInt(int64(rsaKey.E))), sshMpint(rsaKey.N))) + " user@host",
+		"ssh-ed25519": "ssh-ed25519 " + base64.StdEncoding.EncodeToString(sshWire([]byte("ssh-ed25519"), edPub)),
+	}
+
+	for name, key := range keys {
+		pub, err := ParsePublicKey(key)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		switch k := pub.(type) {
+		case *rsa.PublicKey:
+			if k.N.Cmp(rsaKey.N) != 0 {
+				t.Errorf("%s: RSA modulus does not match", name)
+			}
+		case *ecdsa.PublicKey:
+			if !k.Equal(&ecKey.PublicKey) {
+				t.Errorf("%s: EC point does not match", name)
+			}
+		case *ecdh.PublicKey:
+			if k.Curve() == ecdh.P256() && !bytes.Equal(k.Bytes(), ecPoint) {
+				t.Errorf("%s: EC point does not match", name)
+			}
+		default:
+			t.Errorf("%s: unexpected key type %T", name, pub)
+		}
+	}
+
+	if _, err := ParsePublicKey("not a key"); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := func(pub interface{}) string {
+		der, _ := x509.MarshalPKIXPublicKey(pub)
+		sum := sha256.Sum256(der)
+		return hex.EncodeToString(sum[:])
+	}
+
+	b64url := base64.RawURLEncoding.EncodeToString
+	rsaPKIX, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	for expected, keys := range map[string][]string{
+		fingerprint(&rsaKey.PublicKey): {
+			string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPKIX})),
+			string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})),
+			`{"kty":"RSA","n":"` + b64url(rsaKey.N.Bytes()) + `","e":"AQAB"}`,
+		},
+		fingerprint(edPub): {
+			`{"kty":"OKP","crv":"Ed25519","x":"` + b64url(edPub) + `"}`,
+			"ssh-ed25519 " + base64.StdEncoding.EncodeToString(sshWire([]byte("ssh-ed25519"), edPub)),
+		},
+	} {
+		for _, key := range keys {
+			actual, err := Fingerprint(key)
+			if err != nil {
+				t.Errorf("%s: %v", key, err)
+				continue
+			}
+
+			if actual != expected {
+				t.Errorf("Expected fingerprint %s, got %s for %s", expected, actual, key)
+			}
+		}
+	}
+}
+
+func TestParseCertificate(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "host"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafDER, _ := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	leafPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}))
+
+	otherCAKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &otherCAKey.PublicKey, otherCAKey)
+
+	bundle, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(bundle.Name())
+	defer func() { certificateAuthorities = nil }()
+
+	pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	bundle.Close()
+
+	if err := LoadCertificateAuthorities(bundle.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := ParsePublicKey(leafPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !leafKey.PublicKey.Equal(pub) {
+		t.Error("Certificate public key does not match")
+	}
+
+	untrusted := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherDER}))
+	if _, err := ParsePublicKey(untrusted); err == nil {
+		t.Error("Expected an untrusted certificate to be rejected")
+	}
+}
+
+func sshWire(parts ...[]byte) []byte {
+	buf := []byte{}
+	for _, part := range parts {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(part)))
+		buf = append(append(buf, length...), part...)
+	}
+	return buf
+}
+
+// sshMpint pads positive integers whose high bit is set
+func sshMpint(n *big.Int) []byte {
+	data := n.Bytes()
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		data = append([]byte{0}, data...)
+	}
+	return data
+}
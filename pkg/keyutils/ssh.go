@@ -0,0 +1,113 @@
+package keyutils
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var sshCurves = map[string]ecdh.Curve{
+	"ecdsa-sha2-nistp256": ecdh.P256(),
+	"ecdsa-sha2-nistp384": ecdh.P384(),
+}
+
+// parseAuthorizedKey reads a single authorized_keys line. Any options
+// before the key type and the trailing comment are ignored.
+func parseAuthorizedKey(line string) (crypto.PublicKey, error) {
+	fields := strings.Fields(line)
+
+	for i := 0; i < len(fields)-1; i++ {
+		keyType := fields[i]
+		if keyType != "ssh-rsa" && keyType != "ssh-ed25519" && sshCurves[keyType] == nil {
+			continue
+		}
+
+		blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		return parseSSHWireKey(keyType, blob)
+	}
+
+	return nil, errors.New("Unsupported SSH key type, expected ssh-rsa, ssh-ed25519 or ecdsa-sha2-nistp256/384")
+}
+
+// parseSSHWireKey decodes the RFC 4253 public key encoding
+func parseSSHWireKey(keyType string, blob []byte) (crypto.PublicKey, error) {
+	name, blob, err := readSSHString(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(name) != keyType {
+		return nil, fmt.Errorf("SSH key type %s does not match encoded type %s", keyType, name)
+	}
+
+	switch keyType {
+	case "ssh-rsa":
+		e, blob, err := readSSHString(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		n, _, err := readSSHString(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("Invalid ssh-rsa exponent")
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent.Int64()),
+		}, nil
+	case "ssh-ed25519":
+		key, _, err := readSSHString(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("Invalid ssh-ed25519 key")
+		}
+
+		return ed25519.PublicKey(key), nil
+	}
+
+	// ecdsa-sha2-*: curve name then the uncompressed point
+	_, blob, err = readSSHString(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	point, _, err := readSSHString(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	return sshCurves[keyType].NewPublicKey(point)
+}
+
+func readSSHString(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("Truncated SSH key")
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return nil, nil, errors.New("Truncated SSH key")
+	}
+
+	return data[4 : 4+length], data[4+length:], nil
+}
@@ -0,0 +1,99 @@
+package logutils
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Logger wraps logrus so that key material, clear text and cipher text
+// never reach the log.
+//
+// Byte slices, structs and maps are always redacted. Strings, errors,
+// Stringers and numbers are logged as they are so key names, paths and
+// failures stay readable, which makes the caller responsible for them:
+// secret strings must be wrapped with Redact, and errors must never carry
+// clear text, cipher text or key bytes in their message.
+type Logger struct {
+	entry *logrus.Entry
+}
+
+// Sensitive is a value that must not be logged. It formats as a placeholder
+// with every verb, so it stays redacted even when passed to logrus directly.
+type Sensitive struct {
+	length int
+}
+
+// Redact marks a string as sensitive
+func Redact(value string) Sensitive {
+	return Sensitive{length: len(value)}
+}
+
+// String implements fmt.Stringer
+func (s Sensitive) String() string {
+	return fmt.Sprintf("[redacted %d bytes]", s.length)
+}
+
+// Format implements fmt.Formatter
+func (s Sensitive) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, s.String())
+}
+
+// New returns a logger that tags entries with the component that wrote them
+func New(component string) *Logger {
+	return &Logger{
+		entry: logrus.NewEntry(logrus.StandardLogger()).WithField("component", component),
+	}
+}
+
+// WithField returns a logger that adds a redacted field to each entry
+func (l *Logger) WithField(key string, value interface{}) *Logger {
+	return &Logger{entry: l.entry.WithField(key, redact(value))}
+}
+
+// Debugf logs at debug level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.entry.Debugf(format, redactAll(args)...)
+}
+
+// Infof logs at info level
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.entry.Infof(format, redactAll(args)...)
+}
+
+// Warnf logs at warn level
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.entry.Warnf(format, redactAll(args)...)
+}
+
+// Errorf logs at error level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.entry.Errorf(format, redactAll(args)...)
+}
+
+// Error logs at error level
+func (l *Logger) Error(args ...interface{}) {
+	l.entry.Error(redactAll(args)...)
+}
+
+func redactAll(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		redacted[i] = redact(arg)
+	}
+	return redacted
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return Sensitive{length: len(v)}
+	case fmt.Stringer, error, string, bool, int, int64, uint, uint64, float64, Sensitive:
+		// Safe by contract, see Logger
+		return v
+	default:
+		// Structs and maps may hold secrets anywhere inside, only
+		// their type is logged
+		return fmt.Sprintf("[redacted %T]", v)
+	}
+}
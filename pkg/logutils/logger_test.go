@@ -0,0 +1,90 @@
+package logutils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestRedaction(t *testing.T) {
+	logs := &bytes.Buffer{}
+	logrus.SetOutput(logs)
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetOutput(os.Stderr)
+	defer logrus.SetLevel(logrus.InfoLevel)
+
+	log := New("test")
+	log.Debugf("bytes %s %q", []byte("key-bytes"), []byte("key-bytes"))
+	log.Debugf("redacted %s %#v %v", Redact("clear-text"), Redact("clear-text"), Redact("clear-text"))
+	log.WithField("secret", map[string]string{"cipherText": "cipher-text"}).Errorf("struct")
+	logrus.Infof("direct %q", Redact("clear-text"))
+	log.Infof("kept %s %d", "keyName", 42)
+
+	for _, value := range []string{"key-bytes", "clear-text", "cipher-text"} {
+		if strings.Contains(logs.String(), value) {
+			t.Errorf("Logs contain %s: %s", value, logs.String())
+		}
+	}
+
+	if !strings.Contains(logs.String(), "kept keyName 42") || !strings.Contains(logs.String(), "component=test") {
+		t.Errorf("Expected plain values and the component in the logs: %s", logs.String())
+	}
+}
+
+func TestStringsAndErrorsKept(t *testing.T) {
+	logs := &bytes.Buffer{}
+	logrus.SetOutput(logs)
+	defer logrus.SetOutput(os.Stderr)
+
+	log := New("test")
+
+	tests := []struct {
+		name     string
+		log      func()
+		expected string
+		hidden   string
+	}{
+		{
+			name:     "string",
+			log:      func() { log.Infof("Loaded key %s", "mykey") },
+			expected: "Loaded key mykey",
+		},
+		{
+			name:     "path field",
+			log:      func() { log.WithField("path", "/etc/ssl/private/mykey").Infof("field") },
+			expected: `path="/etc/ssl/private/mykey"`,
+		},
+		{
+			name:     "error",
+			log:      func() { log.Errorf("Failed: %v", errors.New("Key not found: mykey")) },
+			expected: "Failed: Key not found: mykey",
+		},
+		{
+			name:     "error value",
+			log:      func() { log.Error(errors.New("Invalid key length")) },
+			expected: "Invalid key length",
+		},
+		{
+			name:     "redacted string",
+			log:      func() { log.Infof("Clear text %s", Redact("clear-text")) },
+			expected: "Clear text [redacted 10 bytes]",
+			hidden:   "clear-text",
+		},
+	}
+
+	for _, test := range tests {
+		logs.Reset()
+		test.log()
+
+		if !strings.Contains(logs.String(), test.expected) {
+			t.Errorf("%s: Expected %q in the logs: %s", test.name, test.expected, logs.String())
+		}
+		if test.hidden != "" && strings.Contains(logs.String(), test.hidden) {
+			t.Errorf("%s: Logs contain %s: %s", test.name, test.hidden, logs.String())
+		}
+	}
+}
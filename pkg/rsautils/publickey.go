@@ -4,12 +4,10 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
-	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
 	"errors"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/rancher/secrets-api/pkg/keyutils"
 )
 
 // RSAPublicKey a struct to hold an RSA Public Key
@@ -32,18 +30,15 @@ func (pk *RSAPublicKey) Encrypt(text string) (string, error) {
 }
 
 func loadRSAPublicKey(key string) (*rsa.PublicKey, error) {
-	block, val := pem.Decode([]byte(key))
-	if block == nil {
-		// This is supposed to be a public key so we can log
-		logrus.Debugf(string(val))
-		return nil, errors.New("Could not decode public key block")
-	}
-	logrus.Debugf("Public Key Block Type: %s", block.Type)
-
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	pub, err := keyutils.ParsePublicKey(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return pub.(*rsa.PublicKey), nil
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Public key is not an RSA key")
+	}
+
+	return rsaPub, nil
 }
@@ -9,8 +9,12 @@ import (
 	"encoding/pem"
 	"errors"
 	"io/ioutil"
+
+	"github.com/rancher/secrets-api/pkg/logutils"
 )
 
+var log = logutils.New("rsautils")
+
 // Decryptor handles decrypting messages
 type Decryptor interface {
 	Decrypt(cipherText string) ([]byte, error)
@@ -28,6 +32,8 @@ func NewRSADecryptorKeyFromFile(privateKeyPath string) (Decryptor, error) {
 		return nil, err
 	}
 
+	log.Debugf("Loaded %d bit RSA private key from %s", key.N.BitLen(), privateKeyPath)
+
 	return rsaDecryptor{
 		privateKeyPath: privateKeyPath,
 		key:            key,
@@ -46,6 +52,11 @@ func NewRSADecryptorKeyFromString(privateKey string) (Decryptor, error) {
 	}, nil
 }
 
+// NewRSADecryptor returns an RSA decryptor for an already parsed key
+func NewRSADecryptor(key *rsa.PrivateKey) Decryptor {
+	return rsaDecryptor{key: key}
+}
+
 //Decrypt implments the decryptor interface
 func (r rsaDecryptor) Decrypt(cipherText string) ([]byte, error) {
 	return rsaDecrypt(r.key, cipherText)
@@ -0,0 +1,183 @@
+package signutils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	// AlgorithmEd25519 signs with an Ed25519 key
+	AlgorithmEd25519 = "Ed25519"
+	// AlgorithmPS256 signs with RSA-PSS over SHA-256
+	AlgorithmPS256 = "PS256"
+)
+
+// Signer signs messages with the server identity key
+type Signer interface {
+	Sign(message []byte) (string, error)
+	Algorithm() string
+	// KeyID is the hex SHA-256 fingerprint of the PKIX encoded public key
+	KeyID() string
+	PublicKey() crypto.PublicKey
+	// PublicKeyPEM is the PKIX "PUBLIC KEY" block consumers verify with
+	PublicKeyPEM() string
+}
+
+type signer struct {
+	key       crypto.Signer
+	algorithm string
+	keyID     string
+	publicPEM string
+}
+
+// NewSignerFromFile loads a PEM Ed25519 or RSA private key
+func NewSignerFromFile(keyPath string) (Signer, error) {
+	keyData, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, errors.New("Could not decode server key. Is it PEM format?")
+	}
+
+	var key interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigner(key)
+}
+
+// NewRandomSigner generates an Ed25519 key that lives as long as the process
+func NewRandomSigner() (Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigner(key)
+}
+
+func newSigner(key interface{}) (Signer, error) {
+	s := &signer{}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		s.key = k
+		s.algorithm = AlgorithmEd25519
+	case *rsa.PrivateKey:
+		s.key = k
+		s.algorithm = AlgorithmPS256
+	default:
+		return nil, fmt.Errorf("Unsupported server key type: %T", key)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(der)
+	s.keyID = hex.EncodeToString(fingerprint[:])
+	s.publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	return s, nil
+}
+
+func (s *signer) Sign(message []byte) (string, error) {
+	var signature []byte
+	var err error
+
+	switch s.algorithm {
+	case AlgorithmEd25519:
+		signature, err = s.key.Sign(rand.Reader, message, crypto.Hash(0))
+	case AlgorithmPS256:
+		digest := sha256.Sum256(message)
+		signature, err = s.key.Sign(rand.Reader, digest[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       crypto.SHA256,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func (s *signer) Algorithm() string {
+	return s.algorithm
+}
+
+func (s *signer) KeyID() string {
+	return s.keyID
+}
+
+func (s *signer) PublicKey() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *signer) PublicKeyPEM() string {
+	return s.publicPEM
+}
+
+// Verify checks a signature made by a Signer with the matching public key
+func Verify(publicKey crypto.PublicKey, algorithm string, message []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	switch algorithm {
+	case AlgorithmEd25519:
+		key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("Ed25519 signature requires an Ed25519 public key")
+		}
+
+		if !ed25519.Verify(key, message, sig) {
+			return errors.New("Invalid server signature")
+		}
+		return nil
+	case AlgorithmPS256:
+		key, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("PS256 signature requires an RSA public key")
+		}
+
+		digest := sha256.Sum256(message)
+		if err := rsa.VerifyPSS(key, crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return errors.New("Invalid server signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported server signature algorithm: %s", algorithm)
+}
+
+// PublicKeyFromPEM parses the PKIX "PUBLIC KEY" block published for a Signer
+func PublicKeyFromPEM(pemKey string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("Could not decode public key block")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
@@ -0,0 +1,21 @@
+// Package testutils holds helpers shared by the tests of several packages.
+package testutils
+
+import (
+	"testing"
+	"time"
+)
+
+// WaitFor polls condition until it is true, failing the test after five
+// seconds. what describes the condition in the failure message.
+func WaitFor(t testing.TB, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,316 @@
+// Package policy decides which callers may use which backend keys. Without
+// a policy every caller may use every key; once one is loaded, requests no
+// rule allows are denied.
+package policy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rancher/secrets-api/pkg/logutils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ActionCreate    = "create"
+	ActionRewrap    = "rewrap"
+	ActionPurge     = "purge"
+	ActionReencrypt = "reencrypt"
+	ActionMigrate   = "migrate"
+)
+
+var log = logutils.New("policy")
+
+var actions = map[string]bool{
+	ActionCreate:    true,
+	ActionRewrap:    true,
+	ActionPurge:     true,
+	ActionReencrypt: true,
+	ActionMigrate:   true,
+}
+
+// IsAction reports whether name is one of the actions rules can allow
+func IsAction(name string) bool {
+	return actions[name]
+}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	// Method is the authenticator that accepted the caller
+	Method  string `json:"method"`
+	Subject string `json:"subject"`
+}
+
+func (i *Identity) String() string {
+	return i.Method + ":" + i.Subject
+}
+
+// Rule allows its subjects to perform its actions with the matching keys
+type Rule struct {
+	// Subjects are identities as "method:subject", "method:*" for every
+	// caller authenticated by method, or "*" for every caller including
+	// unauthenticated ones
+	Subjects []string `json:"subjects" yaml:"subjects"`
+	Actions  []string `json:"actions" yaml:"actions"`
+	// Backends and KeyNames are path.Match patterns, an empty list matches
+	// every backend or key
+	Backends []string `json:"backends,omitempty" yaml:"backends,omitempty"`
+	KeyNames []string `json:"keyNames,omitempty" yaml:"keyNames,omitempty"`
+	// RewrapKeys are the hex SHA-256 fingerprints of the public keys a
+	// rewrap may target, an empty list allows any key
+	RewrapKeys []string `json:"rewrapKeys,omitempty" yaml:"rewrapKeys,omitempty"`
+}
+
+// Policy is a list of rules, a request is allowed when any rule allows it
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Request is an operation to authorize
+type Request struct {
+	Caller  *Identity
+	Action  string
+	Backend string
+	KeyName string
+	// RewrapKey is the fingerprint of the rewrap target key
+	RewrapKey string
+}
+
+func (r Request) String() string {
+	caller := "anonymous"
+	if r.Caller != nil {
+		caller = r.Caller.String()
+	}
+
+	return fmt.Sprintf("%s to %s with %s key %q", caller, r.Action, r.Backend, r.KeyName)
+}
+
+// DeniedError is returned for requests the policy does not allow
+type DeniedError struct {
+	Request Request
+}
+
+func (e *DeniedError) Error() string {
+	if e.Request.Action == ActionRewrap && e.Request.RewrapKey != "" {
+		return fmt.Sprintf("Policy does not allow %s to key %s", e.Request, e.Request.RewrapKey)
+	}
+	return fmt.Sprintf("Policy does not allow %s", e.Request)
+}
+
+// Parse reads a JSON or YAML policy. A document starting with "{" is JSON,
+// anything else YAML. Both reject fields a Rule does not have.
+func Parse(data []byte) (*Policy, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("Policy is empty")
+	}
+
+	policy := &Policy{}
+	if data[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(policy); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range policy.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("Rule %d: %v", i, err)
+		}
+	}
+
+	return policy, nil
+}
+
+// Load reads a JSON or YAML policy file
+func Load(policyPath string) (*Policy, error) {
+	data, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Policy %s: %v", policyPath, err)
+	}
+
+	return policy, nil
+}
+
+func (r *Rule) validate() error {
+	if len(r.Subjects) == 0 {
+		return errors.New("No subjects")
+	}
+
+	if len(r.Actions) == 0 {
+		return errors.New("No actions")
+	}
+
+	for _, action := range r.Actions {
+		if !actions[action] && action != "*" {
+			return fmt.Errorf("Unknown action: %s", action)
+		}
+	}
+
+	for _, pattern := range append(append([]string{}, r.Backends...), r.KeyNames...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	for i, fingerprint := range r.RewrapKeys {
+		fingerprint = strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+		if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != 32 {
+			return fmt.Errorf("Invalid rewrap key fingerprint: %s", r.RewrapKeys[i])
+		}
+		r.RewrapKeys[i] = fingerprint
+	}
+
+	return nil
+}
+
+// Authorize returns a DeniedError unless a rule allows the request
+func (p *Policy) Authorize(request Request) error {
+	for _, rule := range p.Rules {
+		if rule.allows(request) {
+			return nil
+		}
+	}
+
+	return &DeniedError{Request: request}
+}
+
+func (r *Rule) allows(request Request) bool {
+	if !matchSubject(r.Subjects, request.Caller) || !contains(r.Actions, request.Action) {
+		return false
+	}
+
+	if !matchPattern(r.Backends, request.Backend) || !matchPattern(r.KeyNames, request.KeyName) {
+		return false
+	}
+
+	if request.Action == ActionRewrap && len(r.RewrapKeys) > 0 {
+		return contains(r.RewrapKeys, request.RewrapKey)
+	}
+
+	return true
+}
+
+func matchSubject(subjects []string, caller *Identity) bool {
+	for _, subject := range subjects {
+		if subject == "*" {
+			return true
+		}
+
+		if caller == nil {
+			continue
+		}
+
+		if subject == caller.String() || subject == caller.Method+":*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPattern(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	currentLock sync.RWMutex
+	current     *Policy
+	currentPath string
+)
+
+// LoadFile loads the policy file enforced by Authorize
+func LoadFile(policyPath string) error {
+	policy, err := Load(policyPath)
+	if err != nil {
+		return err
+	}
+
+	currentLock.Lock()
+	defer currentLock.Unlock()
+
+	current = policy
+	currentPath = policyPath
+	log.Infof("Loaded %d policy rules from %s", len(policy.Rules), policyPath)
+
+	return nil
+}
+
+// Reload reads the policy file again. The previous policy stays in force
+// when the file is invalid.
+func Reload() error {
+	currentLock.RLock()
+	policyPath := currentPath
+	currentLock.RUnlock()
+
+	if policyPath == "" {
+		return nil
+	}
+
+	return LoadFile(policyPath)
+}
+
+// Set replaces the enforced policy, nil allows everything
+func Set(policy *Policy) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+
+	current = policy
+	currentPath = ""
+}
+
+// Enabled reports whether a policy is enforced
+func Enabled() bool {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+
+	return current != nil
+}
+
+// Authorize checks a request against the loaded policy
+func Authorize(request Request) error {
+	currentLock.RLock()
+	policy := current
+	currentLock.RUnlock()
+
+	if policy == nil {
+		return nil
+	}
+
+	return policy.Authorize(request)
+}
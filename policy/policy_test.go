@@ -0,0 +1,150 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const fingerprint = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func TestAuthorize(t *testing.T) {
+	policy, err := Parse([]byte(`{"rules": [
+		{"subjects": ["jwt:*"], "actions": ["create"], "keyNames": ["team-a/*"]},
+		{"subjects": ["token:deployer"], "actions": ["rewrap"], "backends": ["vault"], "rewrapKeys": ["9F:86:D0:81:88:4C:7D:65:9A:2F:EA:A0:C5:5A:D0:15:A3:BF:4F:1B:2B:0B:82:2C:D1:5D:6C:15:B0:F0:0A:08"]},
+		{"subjects": ["*"], "actions": ["*"], "backends": ["none"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &Identity{Method: "jwt", Subject: "alice"}
+	deployer := &Identity{Method: "token", Subject: "deployer"}
+
+	for _, test := range []struct {
+		request Request
+		allowed bool
+	}{
+		{Request{Caller: alice, Action: ActionCreate, Backend: "vault", KeyName: "team-a/db"}, true},
+		{Request{Caller: alice, Action: ActionCreate, Backend: "vault", KeyName: "team-b/db"}, false},
+		{Request{Caller: alice, Action: ActionRewrap, Backend: "vault", KeyName: "team-a/db", RewrapKey: fingerprint}, false},
+		{Request{Caller: deployer, Action: ActionCreate, Backend: "vault", KeyName: "team-a/db"}, false},
+		{Request{Caller: deployer, Action: ActionRewrap, Backend: "vault", KeyName: "any", RewrapKey: fingerprint}, true},
+		{Request{Caller: deployer, Action: ActionRewrap, Backend: "vault", KeyName: "any", RewrapKey: strings.Repeat("0", 64)}, false},
+		{Request{Caller: deployer, Action: ActionRewrap, Backend: "localkey", KeyName: "any", RewrapKey: fingerprint}, false},
+		{Request{Action: ActionPurge, Backend: "none"}, true},
+		{Request{Action: ActionPurge, Backend: "vault"}, false},
+	} {
+		err := policy.Authorize(test.request)
+		if test.allowed && err != nil {
+			t.Errorf("Expected %s to be allowed: %v", test.request, err)
+		}
+
+		if !test.allowed {
+			if _, ok := err.(*DeniedError); !ok {
+				t.Errorf("Expected %s to be denied, got %v", test.request, err)
+			}
+		}
+	}
+
+	for name, invalid := range map[string]string{
+		"unknown action":  `{"rules": [{"subjects": ["*"], "actions": ["decrypt"]}]}`,
+		"no subjects":     `{"rules": [{"actions": ["create"]}]}`,
+		"bad pattern":     `{"rules": [{"subjects": ["*"], "actions": ["create"], "keyNames": ["["]}]}`,
+		"bad fingerprint": `{"rules": [{"subjects": ["*"], "actions": ["rewrap"], "rewrapKeys": ["abc"]}]}`,
+		"unknown field":   `{"rules": [{"subjects": ["*"], "actions": ["create"], "keys": ["a"]}]}`,
+		"empty":           " \n",
+		"yaml unknown":    "rules:\n- subjects: ['*']\n  actions: [create]\n  keys: [a]\n",
+		"yaml not a list": "rules:\n  subjects: ['*']\n",
+	} {
+		if _, err := Parse([]byte(invalid)); err == nil {
+			t.Errorf("Expected a policy with %s to be rejected", name)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	yamlPolicy, err := Parse([]byte(`
+# Same rules as the JSON policy below
+rules:
+  - subjects: ["jwt:*"]
+    actions: [create]
+    keyNames: ["team-a/*"]
+  - subjects: ["token:deployer"]
+    actions: [rewrap]
+    backends: [vault]
+    rewrapKeys: ["` + strings.ToUpper(fingerprint) + `"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonPolicy, err := Parse([]byte(`{"rules": [
+		{"subjects": ["jwt:*"], "actions": ["create"], "keyNames": ["team-a/*"]},
+		{"subjects": ["token:deployer"], "actions": ["rewrap"], "backends": ["vault"], "rewrapKeys": ["` + fingerprint + `"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(yamlPolicy, jsonPolicy) {
+		t.Errorf("YAML policy %+v differs from the JSON policy %+v", yamlPolicy, jsonPolicy)
+	}
+
+	if _, err := Parse([]byte("rules:\n- subjects: ['*']\n  actions: [decrypt]\n")); err == nil {
+		t.Error("Expected YAML rules to be validated")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer Set(nil)
+
+	request := Request{Caller: &Identity{Method: "token", Subject: "ci"}, Action: ActionCreate, Backend: "none"}
+
+	if err := Authorize(request); err != nil {
+		t.Error("Expected everything to be allowed without a policy")
+	}
+
+	policyPath := path.Join(dir, "policy.json")
+	write := func(data string) {
+		if err := ioutil.WriteFile(policyPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"rules": [{"subjects": ["token:ci"], "actions": ["create"]}]}`)
+	if err := LoadFile(policyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Authorize(request); err != nil {
+		t.Errorf("Expected the loaded policy to allow ci: %v", err)
+	}
+
+	write(`{"rules": [{"subjects": ["token:deployer"], "actions": ["create"]}]}`)
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Authorize(request); err == nil {
+		t.Error("Expected the reloaded policy to deny ci")
+	}
+
+	// A broken file keeps the last good policy
+	write(`{"rules": [`)
+	if err := Reload(); err == nil {
+		t.Error("Expected an invalid policy file to fail the reload")
+	}
+
+	if err := Authorize(request); err == nil {
+		t.Error("Expected the previous policy to stay in force")
+	}
+}
@@ -0,0 +1,131 @@
+package secrets
+
+import (
+	"errors"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/secrets-api/backends"
+)
+
+// keyGroup holds the positions of the secrets in a bulk request that use the
+// same backend and key
+type keyGroup struct {
+	backend string
+	keyName string
+	indexes []int
+}
+
+func groupByKey(count int, key func(i int) (string, string)) []*keyGroup {
+	groups := []*keyGroup{}
+	byKey := map[[2]string]*keyGroup{}
+
+	for i := 0; i < count; i++ {
+		backend, keyName := key(i)
+
+		group, ok := byKey[[2]string{backend, keyName}]
+		if !ok {
+			group = &keyGroup{backend: backend, keyName: keyName}
+			byKey[[2]string{backend, keyName}] = group
+			groups = append(groups, group)
+		}
+		group.indexes = append(group.indexes, i)
+	}
+
+	return groups
+}
+
+// batchClient returns the batch interface of the group's backend, or false
+// when the group should be handled one secret at a time
+func (g *keyGroup) batchClient() (backends.BatchEncryptor, bool) {
+	if len(g.indexes) < 2 {
+		return nil, false
+	}
+
+	backend, err := backends.New(g.backend)
+	if err != nil {
+		return nil, false
+	}
+
+	// Envelope signatures are not checked by BatchVerifySignature
+	if _, ok := backend.(backends.EnvelopeSigner); ok {
+		return nil, false
+	}
+
+	batch, ok := backend.(backends.BatchEncryptor)
+	return batch, ok
+}
+
+// batchSeal encrypts and signs a group of clear secrets in one call each
+func batchSeal(batch backends.BatchEncryptor, group *keyGroup, clearData []*UnencryptedSecret) ([]*EncryptedSecret, error) {
+	clearTexts := make([]string, len(group.indexes))
+	for j, i := range group.indexes {
+		clearTexts[j] = encodeClearText(clearData[i].ClearText)
+	}
+
+	cipherTexts, err := batch.BatchGetEncryptedText(group.keyName, clearTexts)
+	if err != nil {
+		return nil, err
+	}
+
+	signatures, err := batch.BatchSign(group.keyName, clearTexts)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]*EncryptedSecret, len(group.indexes))
+	for j := range group.indexes {
+		sealed[j] = &EncryptedSecret{
+			Resource: client.Resource{
+				Type: "encryptedSecret",
+			},
+			Backend:    group.backend,
+			KeyName:    group.keyName,
+			CipherText: cipherTexts[j],
+			Signature:  signatures[j],
+		}
+	}
+
+	return sealed, nil
+}
+
+// batchVerify decrypts and verifies the secrets of batch capable backends
+// ahead of a bulk rewrap, reencrypt or migrate, so that each secret no longer
+// needs its own backend calls
+func batchVerify(encSecrets []*EncryptedSecret) error {
+	groups := groupByKey(len(encSecrets), func(i int) (string, string) {
+		return encSecrets[i].Backend, encSecrets[i].KeyName
+	})
+
+	for _, group := range groups {
+		batch, ok := group.batchClient()
+		if !ok {
+			continue
+		}
+
+		cipherTexts := make([]string, len(group.indexes))
+		signatures := make([]string, len(group.indexes))
+		for j, i := range group.indexes {
+			cipherTexts[j] = encSecrets[i].CipherText
+			signatures[j] = encSecrets[i].Signature
+		}
+
+		clearTexts, err := batch.BatchGetClearText(group.keyName, cipherTexts)
+		if err != nil {
+			return err
+		}
+
+		verified, err := batch.BatchVerifySignature(group.keyName, signatures, clearTexts)
+		if err != nil {
+			return err
+		}
+
+		for j, i := range group.indexes {
+			if !verified[j] {
+				return errors.New("Signatures did not match")
+			}
+			encSecrets[i].clearText = clearTexts[j]
+		}
+	}
+
+	return nil
+}
@@ -1,11 +1,14 @@
 package secrets
 
 import (
-	"github.com/Sirupsen/logrus"
 	"github.com/rancher/go-rancher/client"
 	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/logutils"
+	"github.com/rancher/secrets-api/policy"
 )
 
+var log = logutils.New("secrets")
+
 func NewBulkSecretInput() *BulkSecretInput {
 
 	return &BulkSecretInput{
@@ -41,11 +44,39 @@ func NewBulkRewrappedSecret(secrets *BulkEncryptedSecret) (*BulkRewrappedSecret,
 	return brs, brs.rewrap(secrets)
 }
 
+func NewBulkReencryptedSecret(secrets *BulkEncryptedSecret) (*BulkEncryptedSecret, error) {
+	bes := &BulkEncryptedSecret{
+		Resource: client.Resource{
+			Type: "bulkEncryptedSecret",
+		},
+		Data: []*EncryptedSecret{},
+	}
+
+	return bes, bes.reencrypt(secrets.Data)
+}
+
+func NewBulkMigratedSecret(secrets *BulkEncryptedSecret) (*BulkEncryptedSecret, error) {
+	bes := &BulkEncryptedSecret{
+		Resource: client.Resource{
+			Type: "bulkEncryptedSecret",
+		},
+		Data: []*EncryptedSecret{},
+	}
+
+	return bes, bes.migrate(secrets)
+}
+
 func (bes *BulkEncryptedSecret) Delete() error {
+	for _, secret := range bes.Data {
+		if err := authorize(secret.caller, policy.ActionPurge, secret.Backend, secret.KeyName); err != nil {
+			return err
+		}
+	}
+
 	for _, secret := range bes.Data {
 		err := secret.Delete()
 		if err != nil {
-			logrus.Error(err)
+			log.Error(err)
 			return err
 		}
 	}
@@ -62,10 +93,22 @@ func (s *BulkRewrappedSecret) rewrap(secrets *BulkEncryptedSecret) error {
 	for _, secret := range secrets.Data {
 		secret.SetTmpKey(tmpKey)
 		secret.RewrapKey = secrets.RewrapKey
+		secret.RewrapFormat = secrets.RewrapFormat
+
+		if err := secret.authorizeRewrap(); err != nil {
+			return err
+		}
+	}
 
+	if err := batchVerify(secrets.Data); err != nil {
+		log.Errorf("Could not decrypt secrets")
+		return err
+	}
+
+	for _, secret := range secrets.Data {
 		rewrapped, err := NewRewrappedSecret(secret)
 		if err != nil {
-			logrus.Errorf("Could not decrypt secret")
+			log.Errorf("Could not decrypt secret")
 			return err
 		}
 		s.Data = append(s.Data, rewrapped)
@@ -74,11 +117,98 @@ func (s *BulkRewrappedSecret) rewrap(secrets *BulkEncryptedSecret) error {
 	return nil
 }
 
+// seal encrypts the secrets, using one batch call per key for backends that
+// support it
 func (bes *BulkEncryptedSecret) seal(clearData []*UnencryptedSecret) error {
-	for _, clear := range clearData {
-		secret, err := NewEncryptedSecret(clear)
+	for _, secret := range clearData {
+		if err := authorize(secret.caller, policy.ActionCreate, secret.Backend, secret.KeyName); err != nil {
+			return err
+		}
+	}
+
+	sealed := make([]*EncryptedSecret, len(clearData))
+
+	groups := groupByKey(len(clearData), func(i int) (string, string) {
+		return clearData[i].Backend, clearData[i].KeyName
+	})
+
+	for _, group := range groups {
+		if batch, ok := group.batchClient(); ok {
+			secrets, err := batchSeal(batch, group, clearData)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			for j, i := range group.indexes {
+				sealed[i] = secrets[j]
+			}
+			continue
+		}
+
+		for _, i := range group.indexes {
+			secret, err := NewEncryptedSecret(clearData[i])
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			sealed[i] = secret
+		}
+	}
+
+	bes.Data = append(bes.Data, sealed...)
+	return nil
+}
+
+func (bes *BulkEncryptedSecret) reencrypt(encData []*EncryptedSecret) error {
+	for _, enc := range encData {
+		if err := authorize(enc.caller, policy.ActionReencrypt, enc.Backend, enc.KeyName); err != nil {
+			return err
+		}
+	}
+
+	if err := batchVerify(encData); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	for _, enc := range encData {
+		secret, err := NewReencryptedSecret(enc)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		bes.Data = append(bes.Data, secret)
+	}
+	return nil
+}
+
+func (bes *BulkEncryptedSecret) migrate(secrets *BulkEncryptedSecret) error {
+	for _, enc := range secrets.Data {
+		if secrets.MigrateBackend != "" {
+			enc.MigrateBackend = secrets.MigrateBackend
+			enc.MigrateKeyName = secrets.MigrateKeyName
+		}
+
+		if enc.MigrateBackend != "" {
+			if err := checkMigrateTarget(enc.MigrateBackend); err != nil {
+				return err
+			}
+			if err := enc.authorizeMigrate(enc.MigrateBackend, enc.MigrateKeyName); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := batchVerify(secrets.Data); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	for _, enc := range secrets.Data {
+		secret, err := NewMigratedSecret(enc)
 		if err != nil {
-			logrus.Error(err)
+			log.Error(err)
 			return err
 		}
 		bes.Data = append(bes.Data, secret)
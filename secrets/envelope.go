@@ -1,12 +1,25 @@
 package secrets
 
 import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
 	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/ecutils"
+	"github.com/rancher/secrets-api/pkg/keyutils"
 	"github.com/rancher/secrets-api/pkg/rsautils"
+	"github.com/rancher/secrets-api/pkg/signutils"
 )
 
-func createMessageEnvelope(rsaKey, message string, tmpKey aesutils.AESKey) (*EncryptedData, error) {
-	pubKey, err := rsautils.PublicKeyFromString(rsaKey)
+var serverSigner signutils.Signer
+
+func createMessageEnvelope(publicKey, message string, tmpKey aesutils.AESKey) (*EncryptedData, error) {
+	pubKey, err := keyutils.ParsePublicKey(publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -24,12 +37,85 @@ func createMessageEnvelope(rsaKey, message string, tmpKey aesutils.AESKey) (*Enc
 		return envelope, err
 	}
 
-	encryptedKey, err := rsaEncryptKey(pubKey, tmpKey)
+	encryptedKey, err := encryptKey(pubKey, tmpKey)
 	if err != nil {
 		return envelope, err
 	}
 
 	envelope.EncryptedKey = *encryptedKey
 
+	if serverSigner != nil {
+		envelope.ServerKeyID = serverSigner.KeyID()
+		envelope.ServerSignatureAlgorithm = serverSigner.Algorithm()
+
+		if envelope.ServerSignature, err = serverSigner.Sign(envelope.ServerSigningInput()); err != nil {
+			return envelope, err
+		}
+	}
+
 	return envelope, nil
 }
+
+// SetServerSigner sets the server identity key rewrap envelopes are signed with
+func SetServerSigner(signer signutils.Signer) {
+	serverSigner = signer
+}
+
+// GetServerKey returns the public server identity key, nil when envelopes
+// are not signed
+func GetServerKey() *ServerKey {
+	if serverSigner == nil {
+		return nil
+	}
+
+	return &ServerKey{
+		Resource: client.Resource{
+			Id:   serverSigner.KeyID(),
+			Type: "serverKey",
+		},
+		Algorithm: serverSigner.Algorithm(),
+		PublicKey: serverSigner.PublicKeyPEM(),
+	}
+}
+
+// ServerSigningInput is the message covered by ServerSignature. It binds
+// the encrypted text, the wrapped key and the HMAC so none of them can be
+// swapped by someone holding only the recipient's public key.
+func (e *EncryptedData) ServerSigningInput() []byte {
+	return []byte(strings.Join([]string{
+		"secrets-api-rewrap-v1",
+		e.EncryptionAlgorithm,
+		e.EncryptedText,
+		e.Signature,
+		e.EncryptedKey.EncryptionAlgorithm,
+		e.EncryptedKey.EncryptedText,
+		e.EncryptedKey.EphemeralPublicKey,
+		e.ServerKeyID,
+	}, "\n"))
+}
+
+// VerifyServerSignature checks the envelope was signed by the server key
+func (e *EncryptedData) VerifyServerSignature(serverKey crypto.PublicKey) error {
+	if e.ServerSignature == "" {
+		return errors.New("Envelope has no server signature")
+	}
+
+	return signutils.Verify(serverKey, e.ServerSignatureAlgorithm, e.ServerSigningInput(), e.ServerSignature)
+}
+
+// encryptKey wraps the AES key for the recipient, the EncryptionAlgorithm of
+// the result tells the consumer which scheme to unwrap with
+func encryptKey(pubKey interface{}, tmpKey aesutils.AESKey) (*RSAEncryptedData, error) {
+	switch key := pubKey.(type) {
+	case *rsa.PublicKey:
+		return rsaEncryptKey(&rsautils.RSAPublicKey{PublicKey: key}, tmpKey)
+	case *ecdsa.PublicKey, *ecdh.PublicKey:
+		ecKey, err := ecutils.NewECPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return ecEncryptKey(ecKey, tmpKey)
+	}
+
+	return nil, errors.New("Unsupported rewrap key type")
+}
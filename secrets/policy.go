@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"github.com/rancher/secrets-api/pkg/keyutils"
+	"github.com/rancher/secrets-api/policy"
+)
+
+// SetCaller records who asked for the secret to be created, for the policy
+// checks
+func (s *UnencryptedSecret) SetCaller(caller *policy.Identity) {
+	s.caller = caller
+}
+
+// SetCaller records who asked for the secret to be used, for the policy
+// checks
+func (s *EncryptedSecret) SetCaller(caller *policy.Identity) {
+	s.caller = caller
+}
+
+// SetCaller sets the caller of every secret in the request
+func (bsi *BulkSecretInput) SetCaller(caller *policy.Identity) {
+	for _, secret := range bsi.Data {
+		secret.SetCaller(caller)
+	}
+}
+
+// SetCaller sets the caller of every secret in the request
+func (bes *BulkEncryptedSecret) SetCaller(caller *policy.Identity) {
+	for _, secret := range bes.Data {
+		secret.SetCaller(caller)
+	}
+}
+
+func authorize(caller *policy.Identity, action, backend, keyName string) error {
+	return policy.Authorize(policy.Request{
+		Caller:  caller,
+		Action:  action,
+		Backend: backend,
+		KeyName: keyName,
+	})
+}
+
+// authorizeRewrap checks that the caller may decrypt the secret and send it
+// to RewrapKey
+func (s *EncryptedSecret) authorizeRewrap() error {
+	if !policy.Enabled() {
+		return nil
+	}
+
+	fingerprint, err := keyutils.Fingerprint(s.RewrapKey)
+	if err != nil {
+		return err
+	}
+
+	return policy.Authorize(policy.Request{
+		Caller:    s.caller,
+		Action:    policy.ActionRewrap,
+		Backend:   s.Backend,
+		KeyName:   s.KeyName,
+		RewrapKey: fingerprint,
+	})
+}
+
+// authorizeMigrate checks that the caller may decrypt the secret and seal it
+// with the target backend and key. Both keys need a migrate rule, create on
+// the target is not enough since a migration moves existing secrets.
+func (s *EncryptedSecret) authorizeMigrate(backend, keyName string) error {
+	if err := authorize(s.caller, policy.ActionMigrate, s.Backend, s.KeyName); err != nil {
+		return err
+	}
+
+	return authorize(s.caller, policy.ActionMigrate, backend, keyName)
+}
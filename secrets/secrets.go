@@ -2,6 +2,7 @@ package secrets
 
 import (
 	"errors"
+	"fmt"
 
 	"encoding/base64"
 	"encoding/json"
@@ -10,7 +11,25 @@ import (
 	"github.com/rancher/go-rancher/client"
 	"github.com/rancher/secrets-api/backends"
 	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/ecutils"
+	"github.com/rancher/secrets-api/pkg/jwe"
+	"github.com/rancher/secrets-api/pkg/jws"
+	"github.com/rancher/secrets-api/pkg/keyutils"
 	"github.com/rancher/secrets-api/pkg/rsautils"
+	"github.com/rancher/secrets-api/policy"
+)
+
+const (
+	// RewrapFormatJWE returns rewrapped secrets as compact JWE
+	RewrapFormatJWE = "jwe"
+	// RewrapFormatJWEJSON returns rewrapped secrets as flattened JSON JWE
+	RewrapFormatJWEJSON = "jwe-json"
+	// RewrapFormatJWSJWE returns rewrapped secrets as a compact JWE inside a
+	// compact JWS signed with the server key
+	RewrapFormatJWSJWE = "jws-jwe"
+	// RewrapFormatJWSJWEJSON returns rewrapped secrets as a flattened JSON
+	// JWE inside a flattened JSON JWS signed with the server key
+	RewrapFormatJWSJWEJSON = "jws-jwe-json"
 )
 
 func GetEncryptedSecretResource() *EncryptedSecret {
@@ -38,6 +57,10 @@ func NewEncryptedSecret(clearSecret *UnencryptedSecret) (*EncryptedSecret, error
 		KeyName: clearSecret.KeyName,
 	}
 
+	if err := authorize(clearSecret.caller, policy.ActionCreate, secret.Backend, secret.KeyName); err != nil {
+		return secret, err
+	}
+
 	return secret, secret.seal(clearSecret.ClearText)
 }
 
@@ -50,6 +73,10 @@ func NewRewrappedSecret(encSecret *EncryptedSecret) (*RewrappedSecret, error) {
 		},
 	}
 
+	if err := encSecret.authorizeRewrap(); err != nil {
+		return secret, err
+	}
+
 	if encSecret.tmpKey == nil {
 		if encSecret.tmpKey, err = aesutils.NewRandomAESKey(32); err != nil {
 			return secret, err
@@ -60,7 +87,52 @@ func NewRewrappedSecret(encSecret *EncryptedSecret) (*RewrappedSecret, error) {
 	return secret, err
 }
 
+// NewReencryptedSecret seals a secret again under the current version of its
+// key. Backends that seal with data keys only have the data key rewrapped.
+func NewReencryptedSecret(encSecret *EncryptedSecret) (*EncryptedSecret, error) {
+	if err := authorize(encSecret.caller, policy.ActionReencrypt, encSecret.Backend, encSecret.KeyName); err != nil {
+		return nil, err
+	}
+
+	if secret, ok, err := encSecret.rewrapDataKey(); ok || err != nil {
+		return secret, err
+	}
+
+	return encSecret.resealAs(encSecret.Backend, encSecret.KeyName)
+}
+
+// NewMigratedSecret decrypts a secret and seals it with the backend and key
+// named by MigrateBackend and MigrateKeyName
+func NewMigratedSecret(encSecret *EncryptedSecret) (*EncryptedSecret, error) {
+	if encSecret.MigrateBackend == "" {
+		return nil, errors.New("No migration backend specified")
+	}
+
+	if err := checkMigrateTarget(encSecret.MigrateBackend); err != nil {
+		return nil, err
+	}
+
+	if err := encSecret.authorizeMigrate(encSecret.MigrateBackend, encSecret.MigrateKeyName); err != nil {
+		return nil, err
+	}
+
+	return encSecret.resealAs(encSecret.MigrateBackend, encSecret.MigrateKeyName)
+}
+
+// checkMigrateTarget refuses the none backend, which only encodes secrets.
+// Migrating to it would hand the clear text to the caller.
+func checkMigrateTarget(backend string) error {
+	if backend == "none" {
+		return errors.New("Secrets can not be migrated to the none backend")
+	}
+	return nil
+}
+
 func (s *EncryptedSecret) Delete() error {
+	if err := authorize(s.caller, policy.ActionPurge, s.Backend, s.KeyName); err != nil {
+		return err
+	}
+
 	backend, err := backends.New(s.Backend)
 	if err != nil {
 		return err
@@ -70,9 +142,7 @@ func (s *EncryptedSecret) Delete() error {
 }
 
 func (s *EncryptedSecret) seal(clearText string) error {
-	if _, err := base64.StdEncoding.DecodeString(clearText); err != nil {
-		clearText = base64.StdEncoding.EncodeToString([]byte(clearText))
-	}
+	clearText = encodeClearText(clearText)
 
 	backend, err := backends.New(s.Backend)
 	if err != nil {
@@ -84,7 +154,11 @@ func (s *EncryptedSecret) seal(clearText string) error {
 		return err
 	}
 
-	s.Signature, err = backend.Sign(s.KeyName, clearText)
+	if signer, ok := backend.(backends.EnvelopeSigner); ok {
+		s.Signature, err = signer.SignEnvelope(s.KeyName, s.CipherText)
+	} else {
+		s.Signature, err = backend.Sign(s.KeyName, clearText)
+	}
 	if err != nil {
 		return err
 	}
@@ -92,7 +166,33 @@ func (s *EncryptedSecret) seal(clearText string) error {
 	return nil
 }
 
+func (s *EncryptedSecret) resealAs(backend, keyName string) (*EncryptedSecret, error) {
+	secret := &EncryptedSecret{
+		Resource: client.Resource{
+			Type: "encryptedSecret",
+		},
+		Backend: backend,
+		KeyName: keyName,
+	}
+
+	clearText, err := s.verifiedClearText()
+	if err != nil {
+		return secret, err
+	}
+
+	return secret, secret.seal(clearText)
+}
+
 func (s *EncryptedSecret) rewrap() (string, error) {
+	switch s.RewrapFormat {
+	case RewrapFormatJWE, RewrapFormatJWEJSON, RewrapFormatJWSJWE, RewrapFormatJWSJWEJSON:
+		return s.rewrapJWE()
+	case "":
+		// the default EncryptedData envelope below
+	default:
+		return "", fmt.Errorf("Unknown rewrap format: %s", s.RewrapFormat)
+	}
+
 	var err error
 	encData, err := s.wrapPlainText()
 	if err != nil {
@@ -114,22 +214,157 @@ func (s *EncryptedSecret) rewrap() (string, error) {
 	return data, nil
 }
 
+// rewrapJWE returns the secret as a JWE. Unlike the default format the
+// payload is the secret itself, not its base64 encoding. The jws-jwe formats
+// make the JWE the payload of a JWS signed with the server key, in the same
+// serialization.
+func (s *EncryptedSecret) rewrapJWE() (string, error) {
+	signed := s.RewrapFormat == RewrapFormatJWSJWE || s.RewrapFormat == RewrapFormatJWSJWEJSON
+	if signed && serverSigner == nil {
+		return "", fmt.Errorf("The %s rewrap format needs a server key", s.RewrapFormat)
+	}
+
+	clearText, err := s.verifiedClearText()
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(clearText)
+	if err != nil {
+		return "", err
+	}
+
+	pubKey, err := keyutils.ParsePublicKey(s.RewrapKey)
+	if err != nil {
+		return "", err
+	}
+
+	cek, err := s.tmpKey.Key()
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := jwe.Encrypt(pubKey, cek, payload)
+	if err != nil {
+		return "", err
+	}
+
+	s.HashAlgorithm = ""
+	s.EncryptionAlgorithm = jwe.EncA256GCM
+
+	jsonSerialization := s.RewrapFormat == RewrapFormatJWEJSON || s.RewrapFormat == RewrapFormatJWSJWEJSON
+
+	serialized := encrypted.Compact()
+	if jsonSerialization {
+		if serialized, err = encrypted.JSON(); err != nil {
+			return "", err
+		}
+	}
+
+	if !signed {
+		return serialized, nil
+	}
+
+	// Like the server signature of the default envelope, a JWS around the
+	// JWE proves it was made by this server
+	jwsSecret, err := jws.Sign(serverSigner, []byte(serialized), "JWE")
+	if err != nil {
+		return "", err
+	}
+
+	if jsonSerialization {
+		return jwsSecret.JSON()
+	}
+
+	return jwsSecret.Compact(), nil
+}
+
 func (s *EncryptedSecret) wrapPlainText() (*EncryptedData, error) {
-	backend, err := backends.New(s.Backend)
+	clearText, err := s.verifiedClearText()
 	if err != nil {
 		return nil, err
 	}
 
+	return createMessageEnvelope(s.RewrapKey, clearText, s.tmpKey)
+}
+
+func (s *EncryptedSecret) verifiedClearText() (string, error) {
+	if s.clearText != "" {
+		return s.clearText, nil
+	}
+
+	backend, err := backends.New(s.Backend)
+	if err != nil {
+		return "", err
+	}
+
+	if signer, ok := backend.(backends.EnvelopeSigner); ok && signer.EnvelopeSigned(s.Signature) {
+		if err := s.verifyEnvelope(signer); err != nil {
+			return "", err
+		}
+		return backend.GetClearText(s.KeyName, s.CipherText)
+	}
+
 	clearText, err := backend.GetClearText(s.KeyName, s.CipherText)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	if match, err := backend.VerifySignature(s.KeyName, s.Signature, clearText); match && err == nil {
-		return createMessageEnvelope(s.RewrapKey, clearText, s.tmpKey)
+		return clearText, nil
+	}
+
+	return "", errors.New("Signatures did not match")
+}
+
+func (s *EncryptedSecret) verifyEnvelope(signer backends.EnvelopeSigner) error {
+	if match, err := signer.VerifyEnvelope(s.KeyName, s.Signature, s.CipherText); match && err == nil {
+		return nil
+	}
+
+	return errors.New("Signatures did not match")
+}
+
+// rewrapDataKey reencrypts a secret of a backend that seals with data keys by
+// wrapping its data key with the newest master key. The secret is never
+// decrypted. It returns false for secrets that must be sealed again instead,
+// such as those signed before envelope signatures.
+func (s *EncryptedSecret) rewrapDataKey() (*EncryptedSecret, bool, error) {
+	backend, err := backends.New(s.Backend)
+	if err != nil {
+		return nil, false, err
+	}
+
+	signer, isSigner := backend.(backends.EnvelopeSigner)
+	rewrapper, isRewrapper := backend.(backends.DataKeyRewrapper)
+	if !isSigner || !isRewrapper || !signer.EnvelopeSigned(s.Signature) {
+		return nil, false, nil
+	}
+
+	if err := s.verifyEnvelope(signer); err != nil {
+		return nil, true, err
+	}
+
+	secret := &EncryptedSecret{
+		Resource: client.Resource{
+			Type: "encryptedSecret",
+		},
+		Backend:   s.Backend,
+		KeyName:   s.KeyName,
+		Signature: s.Signature,
+	}
+
+	secret.CipherText, err = rewrapper.RewrapDataKey(s.KeyName, s.CipherText)
+	return secret, true, err
+}
+
+// encodeClearText base64 encodes clear text that is not already encoded
+func encodeClearText(clearText string) string {
+	if _, err := base64.StdEncoding.DecodeString(clearText); err != nil {
+		return base64.StdEncoding.EncodeToString([]byte(clearText))
 	}
 
-	return nil, errors.New("Signatures did not match")
+	return clearText
 }
 
 func (s *EncryptedSecret) SetTmpKey(key aesutils.AESKey) {
@@ -153,3 +388,22 @@ func rsaEncryptKey(public *rsautils.RSAPublicKey, aes aesutils.AESKey) (*RSAEncr
 		HashAlgorithm:       "sha256",
 	}, nil
 }
+
+func ecEncryptKey(public *ecutils.ECPublicKey, aes aesutils.AESKey) (*RSAEncryptedData, error) {
+	key, err := aes.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralKey, ecText, err := public.Encrypt(string(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &RSAEncryptedData{
+		EncryptedText:       ecText,
+		EncryptionAlgorithm: public.Algorithm(),
+		HashAlgorithm:       "sha256",
+		EphemeralPublicKey:  ephemeralKey,
+	}, nil
+}
@@ -1,12 +1,28 @@
 package secrets
 
 import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
 
+	"github.com/rancher/secrets-api/backends"
+	"github.com/rancher/secrets-api/backends/localkey"
 	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/pkg/ecutils"
+	"github.com/rancher/secrets-api/pkg/jwe"
+	"github.com/rancher/secrets-api/pkg/jws"
+	"github.com/rancher/secrets-api/pkg/keyutils"
 	"github.com/rancher/secrets-api/pkg/rsautils"
+	"github.com/rancher/secrets-api/pkg/signutils"
+	"github.com/rancher/secrets-api/policy"
 )
 
 var (
@@ -125,3 +141,589 @@ func TestRewrapMessage(t *testing.T) {
 	}
 
 }
+
+func TestReencryptSecret(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	if err := os.Mkdir(path.Join(keyPath, "testing"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	config := backends.NewConfig()
+	config.Set("localkey", "keyPath", keyPath)
+	backends.SetBackendConfigs(config)
+
+	writeKey(t, path.Join(keyPath, "testing", "1"))
+
+	secret := GetUnencryptedSecretResource()
+	secret.Backend = "localkey"
+	secret.KeyName = "testing"
+	secret.ClearText = initialText
+
+	encSecret, err := NewEncryptedSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKey(t, path.Join(keyPath, "testing", "2"))
+
+	// Pick up the new version without waiting for the next reload
+	client, err := backends.New("localkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.(*localkey.Client).Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	reencrypted, err := NewReencryptedSecret(encSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the data key is rewrapped, the envelope signature is kept
+	if reencrypted.Signature != encSecret.Signature || reencrypted.CipherText == encSecret.CipherText {
+		t.Errorf("Expected only the data key to be rewrapped, got %s", reencrypted.CipherText)
+	}
+
+	// Retire the old key, the reencrypted secret must not depend on it
+	os.Remove(path.Join(keyPath, "testing", "1"))
+
+	clearText, err := reencrypted.verifiedClearText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clearTextPlain, _ := base64.StdEncoding.DecodeString(clearText)
+	if string(clearTextPlain) != initialText {
+		t.Errorf("String: %s is not the expected %s", clearTextPlain, initialText)
+	}
+}
+
+func writeKey(t *testing.T, keyFile string) {
+	key, err := aesutils.NewRandomAESKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, _ := key.Key()
+	keyData, _ := aesutils.FormatKeyFile(keyBytes, aesutils.KeyFormatRaw)
+	if err := ioutil.WriteFile(keyFile, keyData, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateSecret(t *testing.T) {
+	keyPath, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyPath)
+
+	config := backends.NewConfig()
+	config.Set("localkey", "keyPath", keyPath)
+	backends.SetBackendConfigs(config)
+
+	writeKey(t, path.Join(keyPath, "testing"))
+
+	secret := GetUnencryptedSecretResource()
+	secret.Backend = "none"
+	secret.ClearText = initialText
+
+	encSecret, err := NewEncryptedSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encSecret.MigrateBackend = "localkey"
+	encSecret.MigrateKeyName = "testing"
+
+	migrated, err := NewMigratedSecret(encSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated.Backend != "localkey" || migrated.KeyName != "testing" {
+		t.Errorf("Secret was not migrated, backend: %s key: %s", migrated.Backend, migrated.KeyName)
+	}
+
+	clearText, err := migrated.verifiedClearText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clearTextPlain, _ := base64.StdEncoding.DecodeString(clearText)
+	if string(clearTextPlain) != initialText {
+		t.Errorf("String: %s is not the expected %s", clearTextPlain, initialText)
+	}
+
+	migrated.MigrateBackend = "none"
+	if _, err := NewMigratedSecret(migrated); err == nil {
+		t.Error("Expected migrating to the none backend to fail")
+	}
+
+	bulk := &BulkEncryptedSecret{Data: []*EncryptedSecret{migrated}, MigrateBackend: "none"}
+	if _, err := NewBulkMigratedSecret(bulk); err == nil {
+		t.Error("Expected bulk migrating to the none backend to fail")
+	}
+}
+
+func TestRewrapMessageEC(t *testing.T) {
+	for _, curve := range []ecdh.Curve{ecdh.P256(), ecdh.P384(), ecdh.X25519()} {
+		privKey, err := curve.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pubDER, err := x509.MarshalPKIXPublicKey(privKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		privDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret := GetUnencryptedSecretResource()
+		secret.Backend = "none"
+		secret.ClearText = initialText
+
+		encSecret, err := NewEncryptedSecret(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encSecret.RewrapKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+
+		rewrappedSecret, err := NewRewrappedSecret(encSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encDataDecoded, err := base64.StdEncoding.DecodeString(rewrappedSecret.RewrapText)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encData := &EncryptedData{}
+		if err := json.Unmarshal(encDataDecoded, encData); err != nil {
+			t.Fatal(err)
+		}
+
+		decryptor, err := ecutils.NewECDecryptorKeyFromString(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		aesKey, err := decryptor.Decrypt(encData.EncryptedKey.EphemeralPublicKey, encData.EncryptedKey.EncryptedText)
+		if err != nil {
+			t.Fatalf("%s: %v", encData.EncryptedKey.EncryptionAlgorithm, err)
+		}
+
+		clearText, err := aesutils.GetClearText(aesutils.NewAESKeyFromBytes(aesKey), encData.EncryptedText)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clearTextPlain, _ := base64.StdEncoding.DecodeString(clearText)
+		if string(clearTextPlain) != initialText {
+			t.Errorf("%s: String: %s is not the expected %s", encData.EncryptedKey.EncryptionAlgorithm, clearTextPlain, initialText)
+		}
+	}
+}
+
+func TestRewrapMessageJWE(t *testing.T) {
+	block, _ := pem.Decode([]byte(privateKey()))
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{RewrapFormatJWE, RewrapFormatJWEJSON} {
+		secret := GetUnencryptedSecretResource()
+		secret.Backend = "none"
+		secret.ClearText = initialText
+
+		encSecret, err := NewEncryptedSecret(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encSecret.RewrapKey = publicKey()
+		encSecret.RewrapFormat = format
+
+		rewrappedSecret, err := NewRewrappedSecret(encSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := jwe.Parse(rewrappedSecret.RewrapText)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload, err := parsed.Decrypt(privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(payload) != initialText {
+			t.Errorf("%s: String: %s is not the expected %s", format, payload, initialText)
+		}
+	}
+
+	// Signed JWE needs a server key
+	secret := GetUnencryptedSecretResource()
+	secret.Backend = "none"
+	secret.ClearText = initialText
+
+	encSecret, err := NewEncryptedSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encSecret.RewrapKey = publicKey()
+	encSecret.RewrapFormat = RewrapFormatJWSJWE
+	if _, err := NewRewrappedSecret(encSecret); err == nil {
+		t.Error("Expected the jws-jwe format to fail without a server key")
+	}
+}
+
+func TestRewrapMessageJWESigned(t *testing.T) {
+	block, _ := pem.Decode([]byte(privateKey()))
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := signutils.NewRandomSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetServerSigner(signer)
+	defer SetServerSigner(nil)
+
+	otherSigner, _ := signutils.NewRandomSigner()
+
+	// The jwe formats stay plain JWE with a server key set
+	for _, format := range []string{RewrapFormatJWE, RewrapFormatJWEJSON} {
+		secret := GetUnencryptedSecretResource()
+		secret.Backend = "none"
+		secret.ClearText = initialText
+
+		encSecret, err := NewEncryptedSecret(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encSecret.RewrapKey = publicKey()
+		encSecret.RewrapFormat = format
+
+		rewrappedSecret, err := NewRewrappedSecret(encSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := jwe.Parse(rewrappedSecret.RewrapText)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		if payload, err := parsed.Decrypt(privKey); err != nil || string(payload) != initialText {
+			t.Errorf("%s: Expected a plain JWE of %s, got %q: %v", format, initialText, payload, err)
+		}
+	}
+
+	for _, format := range []string{RewrapFormatJWSJWE, RewrapFormatJWSJWEJSON} {
+		secret := GetUnencryptedSecretResource()
+		secret.Backend = "none"
+		secret.ClearText = initialText
+
+		encSecret, err := NewEncryptedSecret(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encSecret.RewrapKey = publicKey()
+		encSecret.RewrapFormat = format
+
+		rewrappedSecret, err := NewRewrappedSecret(encSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := jws.Parse(rewrappedSecret.RewrapText)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := signed.Verify(otherSigner.PublicKey()); err == nil {
+			t.Errorf("%s: Expected verifying with another server key to fail", format)
+		}
+
+		serialized, err := signed.Verify(signer.PublicKey())
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		parsed, err := jwe.Parse(string(serialized))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload, err := parsed.Decrypt(privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(payload) != initialText {
+			t.Errorf("%s: String: %s is not the expected %s", format, payload, initialText)
+		}
+
+		// A JWE swapped in by someone holding only the recipient's key fails
+		swapped, _ := jwe.Encrypt(&privKey.PublicKey, make([]byte, 32), []byte("forged"))
+		signed.Payload = base64.RawURLEncoding.EncodeToString([]byte(swapped.Compact()))
+		if _, err := signed.Verify(signer.PublicKey()); err == nil {
+			t.Errorf("%s: Expected a swapped JWE to fail verification", format)
+		}
+	}
+}
+
+// batchClient is a test backend that counts single and batch calls
+type batchClient struct {
+	single int
+	batch  int
+}
+
+type batchFactory struct {
+	client *batchClient
+}
+
+func (f batchFactory) Configured(config backends.ConfigSection) bool {
+	return true
+}
+
+func (f batchFactory) New(config backends.ConfigSection) (backends.EncryptorClient, error) {
+	return f.client, nil
+}
+
+func (c *batchClient) GetEncryptedText(keyName, clearText string) (string, error) {
+	c.single++
+	return "enc:" + clearText, nil
+}
+
+func (c *batchClient) GetClearText(keyName, cipherText string) (string, error) {
+	c.single++
+	return strings.TrimPrefix(cipherText, "enc:"), nil
+}
+
+func (c *batchClient) Sign(keyName, text string) (string, error) {
+	c.single++
+	return "sig:" + keyName + ":" + text, nil
+}
+
+func (c *batchClient) VerifySignature(keyName, signature, message string) (bool, error) {
+	c.single++
+	return signature == "sig:"+keyName+":"+message, nil
+}
+
+func (c *batchClient) Delete(keyName, cipherText string) error {
+	return nil
+}
+
+func (c *batchClient) BatchGetEncryptedText(keyName string, clearTexts []string) ([]string, error) {
+	c.batch++
+	results := []string{}
+	for _, clearText := range clearTexts {
+		results = append(results, "enc:"+clearText)
+	}
+	return results, nil
+}
+
+func (c *batchClient) BatchGetClearText(keyName string, cipherTexts []string) ([]string, error) {
+	c.batch++
+	results := []string{}
+	for _, cipherText := range cipherTexts {
+		results = append(results, strings.TrimPrefix(cipherText, "enc:"))
+	}
+	return results, nil
+}
+
+func (c *batchClient) BatchSign(keyName string, texts []string) ([]string, error) {
+	c.batch++
+	results := []string{}
+	for _, text := range texts {
+		results = append(results, "sig:"+keyName+":"+text)
+	}
+	return results, nil
+}
+
+func (c *batchClient) BatchVerifySignature(keyName string, signatures, messages []string) ([]bool, error) {
+	c.batch++
+	results := []bool{}
+	for i := range signatures {
+		results = append(results, signatures[i] == "sig:"+keyName+":"+messages[i])
+	}
+	return results, nil
+}
+
+func TestBulkBatch(t *testing.T) {
+	client := &batchClient{}
+	backends.Register("batchtest", batchFactory{client: client})
+
+	input := NewBulkSecretInput()
+	for _, keyName := range []string{"a", "b", "a", "a"} {
+		input.Data = append(input.Data, &UnencryptedSecret{Backend: "batchtest", KeyName: keyName, ClearText: keyName + "-secret"})
+	}
+
+	bulk, err := NewBulkEncryptedSecret(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key a is sealed with one encrypt and one sign batch, the single key b
+	// secret with single calls
+	if client.batch != 2 || client.single != 2 {
+		t.Errorf("Expected 2 batch and 2 single calls, got %d and %d", client.batch, client.single)
+	}
+
+	for i, keyName := range []string{"a", "b", "a", "a"} {
+		expected := "enc:" + base64.StdEncoding.EncodeToString([]byte(keyName+"-secret"))
+		if bulk.Data[i].KeyName != keyName || bulk.Data[i].CipherText != expected {
+			t.Errorf("Secret %d out of order: %s %s", i, bulk.Data[i].KeyName, bulk.Data[i].CipherText)
+		}
+	}
+
+	client.batch, client.single = 0, 0
+	bulk.RewrapKey = publicKey()
+
+	rewrapped, err := NewBulkRewrappedSecret(bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rewrapped.Data) != 4 || client.batch != 2 || client.single != 2 {
+		t.Errorf("Expected 2 batch and 2 single calls for 4 secrets, got %d and %d", client.batch, client.single)
+	}
+
+	bulk.Data[2].Signature = "sig:a:tampered"
+	if _, err := NewBulkRewrappedSecret(bulk); err == nil {
+		t.Error("Expected a bad signature to fail the bulk rewrap")
+	}
+}
+
+func TestPolicyEnforced(t *testing.T) {
+	client := &batchClient{}
+	backends.Register("policytest", batchFactory{client: client})
+
+	fingerprint, err := keyutils.Fingerprint(publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := policy.Parse([]byte(`{"rules": [
+		{"subjects": ["token:ci"], "actions": ["create", "purge"], "backends": ["policytest"], "keyNames": ["ci-*"]},
+		{"subjects": ["token:ci"], "actions": ["rewrap"], "keyNames": ["ci-*"], "rewrapKeys": ["` + fingerprint + `"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy.Set(rules)
+	defer policy.Set(nil)
+
+	ci := &policy.Identity{Method: "token", Subject: "ci"}
+	intruder := &policy.Identity{Method: "token", Subject: "intruder"}
+
+	create := func(caller *policy.Identity, keyName string) (*EncryptedSecret, error) {
+		input := &UnencryptedSecret{Backend: "policytest", KeyName: keyName, ClearText: initialText}
+		input.SetCaller(caller)
+		return NewEncryptedSecret(input)
+	}
+
+	secret, err := create(ci, "ci-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, denied := range []struct {
+		caller  *policy.Identity
+		keyName string
+	}{{intruder, "ci-app"}, {ci, "prod-db"}, {nil, "ci-app"}} {
+		if _, err := create(denied.caller, denied.keyName); err == nil {
+			t.Errorf("Expected %v to be denied creating with %s", denied.caller, denied.keyName)
+		} else if _, ok := err.(*policy.DeniedError); !ok {
+			t.Errorf("Expected a DeniedError, got %v", err)
+		}
+	}
+
+	secret.SetCaller(ci)
+	secret.RewrapKey = publicKey()
+	if _, err := NewRewrappedSecret(secret); err != nil {
+		t.Errorf("Expected a rewrap to an allowed key to succeed: %v", err)
+	}
+
+	otherKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	otherDER, _ := x509.MarshalPKIXPublicKey(otherKey.PublicKey())
+	secret.RewrapKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: otherDER}))
+	if _, err := NewRewrappedSecret(secret); err == nil {
+		t.Error("Expected a rewrap to another key to be denied")
+	}
+
+	// A bulk request is denied as a whole before the backend is called
+	prod := *secret
+	prod.KeyName = "prod-db"
+	bulk := &BulkEncryptedSecret{Data: []*EncryptedSecret{secret, secret, &prod}, RewrapKey: publicKey()}
+	bulk.SetCaller(ci)
+
+	client.single, client.batch = 0, 0
+	if _, err := NewBulkRewrappedSecret(bulk); err == nil {
+		t.Error("Expected a bulk rewrap including a denied key to fail")
+	}
+	if client.single != 0 || client.batch != 0 {
+		t.Errorf("Expected no backend calls for a denied bulk rewrap, got %d and %d", client.single, client.batch)
+	}
+
+	secret.SetCaller(intruder)
+	if err := secret.Delete(); err == nil {
+		t.Error("Expected a purge by another caller to be denied")
+	}
+
+	// A migration needs migrate on the target, create there is not enough
+	rules, err = policy.Parse([]byte(`{"rules": [
+		{"subjects": ["token:ci"], "actions": ["create"], "backends": ["*"]},
+		{"subjects": ["token:ci"], "actions": ["migrate"], "backends": ["policytest"], "keyNames": ["ci-*"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.Set(rules)
+
+	secret.SetCaller(ci)
+	client.single, client.batch = 0, 0
+	for _, target := range []string{"none", "localkey"} {
+		secret.MigrateBackend, secret.MigrateKeyName = target, "ci-app"
+		if _, err := NewMigratedSecret(secret); err == nil {
+			t.Errorf("Expected a migration to %s to be denied", target)
+		}
+
+		bulk := &BulkEncryptedSecret{Data: []*EncryptedSecret{secret}, MigrateBackend: target, MigrateKeyName: "ci-app"}
+		bulk.SetCaller(ci)
+		if _, err := NewBulkMigratedSecret(bulk); err == nil {
+			t.Errorf("Expected a bulk migration to %s to be denied", target)
+		}
+	}
+	if client.single != 0 || client.batch != 0 {
+		t.Errorf("Expected no backend calls for denied migrations, got %d and %d", client.single, client.batch)
+	}
+
+	secret.MigrateBackend, secret.MigrateKeyName = "policytest", "ci-other"
+	if _, err := NewMigratedSecret(secret); err != nil {
+		t.Errorf("Expected a migration between allowed keys to succeed: %v", err)
+	}
+}
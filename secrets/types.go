@@ -3,6 +3,7 @@ package secrets
 import (
 	"github.com/rancher/go-rancher/client"
 	"github.com/rancher/secrets-api/pkg/aesutils"
+	"github.com/rancher/secrets-api/policy"
 )
 
 type SecretCollection struct {
@@ -17,8 +18,11 @@ type BulkSecretInput struct {
 
 type BulkEncryptedSecret struct {
 	client.Resource
-	Data      []*EncryptedSecret `json:"data,omitempty"`
-	RewrapKey string             `json:"rewrapKey,omitempty"`
+	Data           []*EncryptedSecret `json:"data,omitempty"`
+	RewrapKey      string             `json:"rewrapKey,omitempty"`
+	RewrapFormat   string             `json:"rewrapFormat,omitempty"`
+	MigrateBackend string             `json:"migrateBackend,omitempty"`
+	MigrateKeyName string             `json:"migrateKeyName,omitempty"`
 }
 
 type BulkRewrappedSecret struct {
@@ -31,6 +35,7 @@ type UnencryptedSecret struct {
 	Backend   string `json:"backend"`
 	KeyName   string `json:"keyName"`
 	ClearText string `json:"clearText,omitempty"`
+	caller    *policy.Identity
 }
 
 type EncryptedSecret struct {
@@ -42,7 +47,14 @@ type EncryptedSecret struct {
 	EncryptionAlgorithm string `json:"encryptionAglorigthm"`
 	Signature           string `json:"signature"`
 	RewrapKey           string `json:"rewrapKey,omitempty"`
+	RewrapFormat        string `json:"rewrapFormat,omitempty"`
+	MigrateBackend      string `json:"migrateBackend,omitempty"`
+	MigrateKeyName      string `json:"migrateKeyName,omitempty"`
 	tmpKey              aesutils.AESKey
+	// clearText is set when a bulk request already decrypted and
+	// verified the secret
+	clearText string
+	caller    *policy.Identity
 }
 
 type RewrappedSecret struct {
@@ -56,15 +68,30 @@ type Secret struct {
 }
 
 type EncryptedData struct {
-	EncryptionAlgorithm string           `json:"encryptionAlgorithm,omitempty"`
-	EncryptedText       string           `json:"encryptedText,omitempty"`
-	HashAlgorithm       string           `json:"hashAlgorithm,omitempty"`
-	EncryptedKey        RSAEncryptedData `json:"encryptedKey,omitempty"`
-	Signature           string           `json:"signature,omitempty"`
+	EncryptionAlgorithm      string           `json:"encryptionAlgorithm,omitempty"`
+	EncryptedText            string           `json:"encryptedText,omitempty"`
+	HashAlgorithm            string           `json:"hashAlgorithm,omitempty"`
+	EncryptedKey             RSAEncryptedData `json:"encryptedKey,omitempty"`
+	Signature                string           `json:"signature,omitempty"`
+	ServerKeyID              string           `json:"serverKeyId,omitempty"`
+	ServerSignatureAlgorithm string           `json:"serverSignatureAlgorithm,omitempty"`
+	ServerSignature          string           `json:"serverSignature,omitempty"`
 }
 
+// ServerKey is the public half of the key rewrap envelopes are signed with
+type ServerKey struct {
+	client.Resource
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"`
+}
+
+// RSAEncryptedData holds the wrapped AES key. Despite the name it is also
+// used for EC recipients, EncryptionAlgorithm is PKCS1_OAEP for RSA keys and
+// ECDH-ES-P256, ECDH-ES-P384 or ECDH-ES-X25519 for EC keys, which also set
+// EphemeralPublicKey.
 type RSAEncryptedData struct {
 	EncryptionAlgorithm string `json:"encryptionAlgorithm,omitempty"`
 	EncryptedText       string `json:"encryptedText,omitempty"`
 	HashAlgorithm       string `json:"hashAlgorithm,omitempty"`
+	EphemeralPublicKey  string `json:"ephemeralPublicKey,omitempty"`
 }
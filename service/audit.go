@@ -0,0 +1,160 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"regexp"
+
+	"github.com/gorilla/context"
+	"github.com/rancher/secrets-api/audit"
+	"github.com/rancher/secrets-api/pkg/keyutils"
+	"github.com/rancher/secrets-api/policy"
+	"github.com/rancher/secrets-api/secrets"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// auditedKey marks requests whose handler recorded an audit event
+type auditedKey struct{}
+
+// validRequestID limits the request ids taken from clients to what is safe
+// to copy into logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID returns the id of a request, taken from its X-Request-Id header
+// or generated
+func RequestID(r *http.Request) string {
+	id, _ := context.Get(r, requestIDKey{}).(string)
+	return id
+}
+
+// assignRequestID gives every request an id, returned in the X-Request-Id
+// response header and recorded in the audit log
+func assignRequestID(handler func(http.ResponseWriter, *http.Request) (int, error)) func(http.ResponseWriter, *http.Request) (int, error) {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		context.Set(r, requestIDKey{}, id)
+		w.Header().Set(requestIDHeader, id)
+
+		return handler(w, r)
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// auditRejections records requests that authentication or the socket
+// allow-lists turned away. Requests that reach a handler are audited there,
+// including those the policy denies.
+func auditRejections(handler func(http.ResponseWriter, *http.Request) (int, error)) func(http.ResponseWriter, *http.Request) (int, error) {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		code, err := handler(w, r)
+		if err == nil || (code != http.StatusUnauthorized && code != http.StatusForbidden) || !audit.Enabled() {
+			return code, err
+		}
+
+		if audited, _ := context.Get(r, auditedKey{}).(bool); audited {
+			return code, err
+		}
+
+		action := ""
+		if r.Method == "POST" && policy.IsAction(path.Base(r.URL.Path)) {
+			action = path.Base(r.URL.Path)
+		}
+
+		event := auditEvent(r, action, r.URL.Query().Get("action") == "bulk")
+		event.Path = r.URL.Path
+		event.Outcome = audit.OutcomeDenied
+		event.Error = err.Error()
+		writeAudit(event)
+
+		return code, err
+	}
+}
+
+// auditEvent starts the audit record of an operation on behalf of r
+func auditEvent(r *http.Request, action string, bulk bool) *audit.Event {
+	context.Set(r, auditedKey{}, true)
+
+	return &audit.Event{
+		RequestID: RequestID(r),
+		Caller:    CallerIdentity(r),
+		Action:    action,
+		Bulk:      bulk,
+		Keys:      []audit.Key{},
+	}
+}
+
+// recordAudit completes an event with the outcome of the operation and
+// writes it
+func recordAudit(event *audit.Event, err error) {
+	event.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		event.Outcome = audit.OutcomeError
+		if _, ok := err.(*policy.DeniedError); ok {
+			event.Outcome = audit.OutcomeDenied
+		}
+		event.Error = err.Error()
+	}
+
+	writeAudit(event)
+}
+
+func writeAudit(event *audit.Event) {
+	if err := audit.Record(event); err != nil {
+		log.Errorf("Request %s was not audited, %d audit events lost: %v", event.RequestID, audit.Failures(), err)
+	}
+}
+
+func auditCreate(r *http.Request, bulk bool, clearSecrets []*secrets.UnencryptedSecret, err error) {
+	if !audit.Enabled() {
+		return
+	}
+
+	event := auditEvent(r, policy.ActionCreate, bulk)
+	for _, secret := range clearSecrets {
+		event.Keys = audit.AddKey(event.Keys, secret.Backend, secret.KeyName)
+	}
+
+	recordAudit(event, err)
+}
+
+// auditEncrypted records an operation on encrypted secrets. For migrations
+// the keys named by migrateBackend and migrateKeyName, or by each secret,
+// are recorded as the targets.
+func auditEncrypted(r *http.Request, action string, bulk bool, encSecrets []*secrets.EncryptedSecret, rewrapKey, migrateBackend, migrateKeyName string, err error) {
+	if !audit.Enabled() {
+		return
+	}
+
+	event := auditEvent(r, action, bulk)
+	for _, secret := range encSecrets {
+		event.Keys = audit.AddKey(event.Keys, secret.Backend, secret.KeyName)
+
+		if action == policy.ActionMigrate {
+			backend, keyName := secret.MigrateBackend, secret.MigrateKeyName
+			if migrateBackend != "" {
+				backend, keyName = migrateBackend, migrateKeyName
+			}
+			event.TargetKeys = audit.AddKey(event.TargetKeys, backend, keyName)
+		}
+	}
+
+	if rewrapKey != "" {
+		// An unparseable key fails the rewrap itself, which is recorded
+		event.RewrapKey, _ = keyutils.Fingerprint(rewrapKey)
+	}
+
+	recordAudit(event, err)
+}
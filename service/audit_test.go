@@ -0,0 +1,160 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/rancher/secrets-api/audit"
+	"github.com/rancher/secrets-api/pkg/keyutils"
+	"github.com/rancher/secrets-api/policy"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := path.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret-token-1 deployer\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := NewTokenAuthenticator(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setAuthenticators(Config{Authenticators: []Authenticator{tokens}})
+	defer setAuthenticators(Config{})
+
+	rules, err := policy.Parse([]byte(`{"rules": [{"subjects": ["token:deployer"], "actions": ["create", "rewrap"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.Set(rules)
+	defer policy.Set(nil)
+
+	out := &bufferCloser{}
+	audit.SetLogger(audit.NewLogger(out, true, ""))
+	defer audit.SetLogger(nil)
+
+	server := httptest.NewServer(NewRouter())
+	defer server.Close()
+
+	post := func(action, requestID, body string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("POST", server.URL+"/v1-secrets/secrets/"+action, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret-token-1")
+		if requestID != "" {
+			req.Header.Set("X-Request-Id", requestID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp, data
+	}
+
+	resp, created := post("create", "build-42", `{"backend": "none", "keyName": "app", "clearText": "c3VwZXItc2VjcmV0"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Create failed with %d: %s", resp.StatusCode, created)
+	}
+	if resp.Header.Get("X-Request-Id") != "build-42" {
+		t.Errorf("Expected the client request id to be echoed, got %q", resp.Header.Get("X-Request-Id"))
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	rewrapKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	fingerprint, _ := keyutils.Fingerprint(rewrapKey)
+
+	secret := map[string]interface{}{}
+	json.Unmarshal(created, &secret)
+	secret["rewrapKey"] = rewrapKey
+	rewrapBody, _ := json.Marshal(secret)
+
+	if resp, data := post("rewrap", "bad id {}", string(rewrapBody)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Rewrap failed with %d: %s", resp.StatusCode, data)
+	}
+
+	if resp, _ := post("purge?action=bulk", "", `{"data": [`+string(created)+`, `+string(created)+`]}`); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the policy to deny the purge, got %d", resp.StatusCode)
+	}
+
+	// Requests authentication turns away are audited too
+	req, _ := http.NewRequest("POST", server.URL+"/v1-secrets/secrets/rewrap?action=bulk", strings.NewReader(`{"data": []}`))
+	req.Header.Set("X-Request-Id", "anonymous-1")
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a request without a token to get 401, got %d", resp.StatusCode)
+	}
+
+	logged := out.String()
+	if strings.Contains(logged, "c3VwZXItc2VjcmV0") || strings.Contains(logged, "super-secret") {
+		t.Error("Audit log contains the secret")
+	}
+
+	if _, err := audit.Verify(strings.NewReader(logged)); err != nil {
+		t.Error(err)
+	}
+
+	events := []audit.Event{}
+	for _, line := range strings.Split(strings.TrimSpace(logged), "\n") {
+		event := audit.Event{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 audit events, got %d", len(events))
+	}
+
+	create, rewrap, purge, rejected := events[0], events[1], events[2], events[3]
+	if create.Action != "create" || create.RequestID != "build-42" || create.Outcome != audit.OutcomeSuccess ||
+		create.Caller == nil || create.Caller.String() != "token:deployer" ||
+		len(create.Keys) != 1 || create.Keys[0].Backend != "none" || create.Keys[0].KeyName != "app" {
+		t.Errorf("Unexpected create event %+v", create)
+	}
+
+	if rewrap.Action != "rewrap" || rewrap.RewrapKey != fingerprint || len(rewrap.RequestID) != 32 {
+		t.Errorf("Unexpected rewrap event %+v", rewrap)
+	}
+
+	if purge.Action != "purge" || !purge.Bulk || purge.Outcome != audit.OutcomeDenied || purge.Keys[0].Count != 2 {
+		t.Errorf("Unexpected purge event %+v", purge)
+	}
+
+	if rejected.Action != "rewrap" || !rejected.Bulk || rejected.Outcome != audit.OutcomeDenied ||
+		rejected.RequestID != "anonymous-1" || rejected.Caller != nil || rejected.Path != "/v1-secrets/secrets/rewrap" {
+		t.Errorf("Unexpected rejected event %+v", rejected)
+	}
+}
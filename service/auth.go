@@ -0,0 +1,369 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/context"
+	"github.com/rancher/secrets-api/pkg/jwt"
+	"github.com/rancher/secrets-api/policy"
+)
+
+const (
+	AuthMethodToken  = "token"
+	AuthMethodJWT    = "jwt"
+	AuthMethodMTLS   = "mtls"
+	AuthMethodSocket = "socket"
+)
+
+type identityKey struct{}
+
+// Identity is the authenticated caller of a request
+type Identity = policy.Identity
+
+// Authenticator identifies the caller of a request. It returns nil and no
+// error when the request carries no credentials it understands, so the
+// next authenticator can be tried.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Reloader is implemented by authenticators backed by files
+type Reloader interface {
+	Reload() error
+}
+
+// ForbiddenError is returned by an authenticator that recognized the caller
+// but does not accept it
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+var authenticators []Authenticator
+
+func setAuthenticators(config Config) {
+	authenticators = config.Authenticators
+}
+
+// CallerIdentity returns the identity a request was authenticated as. It is
+// nil when no authenticators are configured.
+func CallerIdentity(r *http.Request) *Identity {
+	identity, _ := context.Get(r, identityKey{}).(*Identity)
+	return identity
+}
+
+// ReloadAuthenticators re-reads the token and key files of the configured
+// authenticators
+func ReloadAuthenticators() error {
+	for _, authenticator := range authenticators {
+		if reloader, ok := authenticator.(Reloader); ok {
+			if err := reloader.Reload(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// authenticate rejects requests no authenticator accepts with a 401, or a
+// 403 when an authenticator refused the caller. With no authenticators
+// configured every request is let through.
+func authenticate(handler func(http.ResponseWriter, *http.Request) (int, error)) func(http.ResponseWriter, *http.Request) (int, error) {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if len(authenticators) == 0 {
+			return handler(w, r)
+		}
+
+		identity, err := authenticateRequest(r)
+		if err != nil {
+			if _, ok := err.(*ForbiddenError); ok {
+				return http.StatusForbidden, err
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="secrets-api"`)
+			return http.StatusUnauthorized, err
+		}
+
+		context.Set(r, identityKey{}, identity)
+
+		return handler(w, r)
+	}
+}
+
+func authenticateRequest(r *http.Request) (*Identity, error) {
+	var failure error
+
+	for _, authenticator := range authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if _, ok := err.(*ForbiddenError); ok {
+			return nil, err
+		}
+
+		if err != nil {
+			// Keep the reason out of the response, it can help an attacker
+			log.Warnf("Authentication failed: %v", err)
+			failure = errors.New("Invalid credentials")
+			continue
+		}
+
+		if identity != nil {
+			return identity, nil
+		}
+	}
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	return nil, errors.New("Authentication required")
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+type tokenAuthenticator struct {
+	path string
+
+	lock   sync.RWMutex
+	tokens map[[sha256.Size]byte]string
+}
+
+// NewTokenAuthenticator accepts the static bearer tokens listed in path, one
+// "<token> <name>" pair per line. The name is the subject of the caller.
+// Blank lines and lines starting with # are ignored.
+func NewTokenAuthenticator(path string) (Authenticator, error) {
+	authenticator := &tokenAuthenticator{path: path}
+	if err := authenticator.Reload(); err != nil {
+		return nil, err
+	}
+
+	return authenticator, nil
+}
+
+func (t *tokenAuthenticator) Reload() error {
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+
+	// Only hashes are kept, comparing them does not leak the tokens
+	tokens := map[[sha256.Size]byte]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("Token file %s line %d: expected \"<token> <name>\"", t.path, lineNumber)
+		}
+
+		tokens[sha256.Sum256([]byte(fields[0]))] = fields[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	t.tokens = tokens
+	t.lock.Unlock()
+
+	log.Infof("Loaded %d bearer tokens from %s", len(tokens), t.path)
+
+	return nil
+}
+
+func (t *tokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	t.lock.RLock()
+	name, ok := t.tokens[sha256.Sum256([]byte(token))]
+	t.lock.RUnlock()
+
+	if !ok {
+		// Leave the token to the JWT authenticator if there is one
+		if strings.Count(token, ".") == 2 {
+			return nil, nil
+		}
+		return nil, errors.New("Unknown bearer token")
+	}
+
+	return &Identity{Method: AuthMethodToken, Subject: name}, nil
+}
+
+type jwtAuthenticator struct {
+	path    string
+	options jwt.VerifyOptions
+
+	lock   sync.RWMutex
+	keySet *jwt.KeySet
+}
+
+// NewJWTAuthenticator accepts bearer JWTs signed by a key in the JWKS file
+// at path. The sub claim is the subject of the caller; issuer and audience
+// are checked when not empty.
+func NewJWTAuthenticator(path, issuer, audience string) (Authenticator, error) {
+	authenticator := &jwtAuthenticator{
+		path: path,
+		options: jwt.VerifyOptions{
+			Issuer:   issuer,
+			Audience: audience,
+		},
+	}
+
+	if err := authenticator.Reload(); err != nil {
+		return nil, err
+	}
+
+	return authenticator, nil
+}
+
+func (j *jwtAuthenticator) Reload() error {
+	keySet, err := jwt.LoadKeySet(j.path)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	j.keySet = keySet
+	j.lock.Unlock()
+
+	log.Infof("Loaded %d JWT signing keys from %s", keySet.Len(), j.path)
+
+	return nil
+}
+
+func (j *jwtAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" || strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	j.lock.RLock()
+	keySet := j.keySet
+	j.lock.RUnlock()
+
+	claims, err := keySet.Verify(token, j.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("Token has no subject")
+	}
+
+	return &Identity{Method: AuthMethodJWT, Subject: claims.Subject}, nil
+}
+
+type clientCertAuthenticator struct {
+	subjects map[string]bool
+}
+
+// Sources of client certificate names. Names are prefixed with their source
+// so a DNS SAN can not pass for a common name or an email address.
+var certNameSources = []string{"cn:", "dns:", "email:", "uri:"}
+
+// NewClientCertAuthenticator accepts TLS clients whose verified certificate
+// has a name in subjects, given as cn:<common name>, dns:<name>,
+// email:<address> or uri:<uri>. "*" accepts any verified certificate.
+// Clients with other certificates get a 403.
+func NewClientCertAuthenticator(subjects []string) (Authenticator, error) {
+	authenticator := &clientCertAuthenticator{subjects: map[string]bool{}}
+	for _, subject := range subjects {
+		if subject != "*" && !hasCertNameSource(subject) {
+			return nil, fmt.Errorf("Client certificate subject %q needs a cn:, dns:, email: or uri: prefix", subject)
+		}
+		authenticator.subjects[subject] = true
+	}
+
+	return authenticator, nil
+}
+
+func hasCertNameSource(subject string) bool {
+	for _, source := range certNameSources {
+		if strings.HasPrefix(subject, source) && len(subject) > len(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// certNames lists the names of a certificate, prefixed with their source
+func certNames(cert *x509.Certificate) []string {
+	names := []string{}
+	if cert.Subject.CommonName != "" {
+		names = append(names, "cn:"+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		names = append(names, "dns:"+name)
+	}
+	for _, address := range cert.EmailAddresses {
+		names = append(names, "email:"+address)
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "uri:"+uri.String())
+	}
+	return names
+}
+
+func (c *clientCertAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	names := certNames(r.TLS.VerifiedChains[0][0])
+
+	for _, name := range names {
+		if c.subjects[name] {
+			return &Identity{Method: AuthMethodMTLS, Subject: name}, nil
+		}
+	}
+
+	if c.subjects["*"] && len(names) > 0 {
+		return &Identity{Method: AuthMethodMTLS, Subject: names[0]}, nil
+	}
+
+	return nil, &ForbiddenError{Message: "Client certificate is not allowed"}
+}
+
+type peerAuthenticator struct{}
+
+// NewPeerAuthenticator accepts requests made over the unix socket, as the
+// uid of the connecting process
+func NewPeerAuthenticator() Authenticator {
+	return peerAuthenticator{}
+}
+
+func (peerAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	cred, ok := PeerCredentials(r)
+	if !ok {
+		return nil, nil
+	}
+
+	return &Identity{Method: AuthMethodSocket, Subject: strconv.FormatUint(uint64(cred.UID), 10)}, nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signTestJWT(key ed25519.PrivateKey, claims string) string {
+	b64 := base64.RawURLEncoding
+	signed := b64.EncodeToString([]byte(`{"alg":"EdDSA","typ":"JWT"}`)) + "." + b64.EncodeToString([]byte(claims))
+	return signed + "." + b64.EncodeToString(ed25519.Sign(key, []byte(signed)))
+}
+
+func TestAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := path.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokenFile, []byte("# CI deploy token\nsecret-token-1 deployer\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	jwksFile := path.Join(dir, "jwks.json")
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": %q}]}`, base64.RawURLEncoding.EncodeToString(public))
+	if err := ioutil.WriteFile(jwksFile, []byte(jwks), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := NewTokenAuthenticator(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwts, err := NewJWTAuthenticator(jwksFile, "", "secrets-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setAuthenticators(Config{Authenticators: []Authenticator{tokens, jwts}})
+	defer setAuthenticators(Config{})
+
+	server := httptest.NewServer(NewRouter())
+	defer server.Close()
+
+	exp := time.Now().Add(time.Hour).Unix()
+	create := func(authorization string) (int, *errObj, *http.Response) {
+		req, _ := http.NewRequest("POST", server.URL+"/v1-secrets/secrets/create",
+			strings.NewReader(`{"backend": "none", "clearText": "aGVsbG8="}`))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		errResponse := &errObj{}
+		json.NewDecoder(resp.Body).Decode(errResponse)
+		return resp.StatusCode, errResponse, resp
+	}
+
+	code, errResponse, resp := create("")
+	if code != http.StatusUnauthorized || errResponse.Type != "error" || errResponse.Status != "401" {
+		t.Errorf("Expected a 401 error object without credentials, got %d %+v", code, errResponse)
+	}
+	if resp.Header.Get("WWW-Authenticate") == "" {
+		t.Error("Expected a WWW-Authenticate challenge")
+	}
+
+	for name, authorization := range map[string]string{
+		"static token": "Bearer secret-token-1",
+		"JWT":          "Bearer " + signTestJWT(private, fmt.Sprintf(`{"sub": "ci", "aud": "secrets-api", "exp": %d}`, exp)),
+	} {
+		if code, _, _ := create(authorization); code != http.StatusOK {
+			t.Errorf("Expected a valid %s to be accepted, got %d", name, code)
+		}
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	for name, authorization := range map[string]string{
+		"unknown token":      "Bearer secret-token-2",
+		"JWT from other key": "Bearer " + signTestJWT(otherKey, fmt.Sprintf(`{"sub": "ci", "aud": "secrets-api", "exp": %d}`, exp)),
+		"JWT for other aud":  "Bearer " + signTestJWT(private, fmt.Sprintf(`{"sub": "ci", "aud": "vault", "exp": %d}`, exp)),
+		"basic auth":         "Basic ZGVwbG95ZXI6c2VjcmV0",
+	} {
+		if code, _, _ := create(authorization); code != http.StatusUnauthorized {
+			t.Errorf("Expected the %s to get 401, got %d", name, code)
+		}
+	}
+
+	// The schemas stay readable so clients can discover the API
+	schemaResp, err := http.Get(server.URL + "/v1-secrets/schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaResp.Body.Close()
+
+	if schemaResp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the schemas without credentials, got %d", schemaResp.StatusCode)
+	}
+
+	// Tokens removed from the file stop working after a reload
+	if err := ioutil.WriteFile(tokenFile, []byte("secret-token-3 deployer\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReloadAuthenticators(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, _ := create("Bearer secret-token-1"); code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to get 401, got %d", code)
+	}
+}
+
+func TestClientCertAuthentication(t *testing.T) {
+	ca := newTestCert(t, "ca", 1, nil)
+	builder := newTestCert(t, "builder", 2, ca)
+	intruder := newTestCert(t, "intruder", 3, ca)
+
+	authenticator, err := NewClientCertAuthenticator([]string{"cn:builder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setAuthenticators(Config{Authenticators: []Authenticator{authenticator}})
+	defer setAuthenticators(Config{})
+
+	router := NewRouter()
+
+	var identity *Identity
+	get := func(cert *x509.Certificate) int {
+		req := httptest.NewRequest("GET", "/v1-secrets/backends", nil)
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}}}
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if code := get(builder.cert); code != http.StatusOK {
+		t.Errorf("Expected an allowed client certificate to be accepted, got %d", code)
+	}
+
+	if code := get(intruder.cert); code != http.StatusForbidden {
+		t.Errorf("Expected a client certificate off the list to get 403, got %d", code)
+	}
+
+	// A DNS SAN matching the allowed common name is not the same name
+	spoofed := *intruder.cert
+	spoofed.DNSNames = []string{"builder"}
+	if code := get(&spoofed); code != http.StatusForbidden {
+		t.Errorf("Expected a DNS SAN not to match a common name, got %d", code)
+	}
+
+	if code := get(nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected a request without a certificate to get 401, got %d", code)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{builder.cert, ca.cert}}}
+
+	wrapped := authenticate(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		identity = CallerIdentity(r)
+		return http.StatusOK, nil
+	})
+
+	if _, err := wrapped(httptest.NewRecorder(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if identity == nil || identity.String() != "mtls:cn:builder" {
+		t.Errorf("Expected the caller to be mtls:cn:builder, got %v", identity)
+	}
+
+	for _, subjects := range [][]string{{"builder"}, {"cn:"}, {"cn:builder", "spiffe://builder"}} {
+		if _, err := NewClientCertAuthenticator(subjects); err == nil {
+			t.Errorf("Expected subjects %v without a name source to be rejected", subjects)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rancher/secrets-api/backends"
+	"github.com/rancher/secrets-api/backends/none"
+)
+
+// unhealthyClient is a none client whose health check fails with an error
+// naming an internal endpoint
+type unhealthyClient struct {
+	none.Client
+	unhealthy *int32
+}
+
+func (c *unhealthyClient) Health() error {
+	if atomic.LoadInt32(c.unhealthy) != 0 {
+		return errors.New("token expired at https://vault.internal:8200")
+	}
+	return nil
+}
+
+type listTestFactory struct {
+	created   int32
+	unhealthy int32
+}
+
+func (f *listTestFactory) Configured(config backends.ConfigSection) bool {
+	return config.Get("enabled") != ""
+}
+
+func (f *listTestFactory) New(config backends.ConfigSection) (backends.EncryptorClient, error) {
+	atomic.AddInt32(&f.created, 1)
+	return &unhealthyClient{unhealthy: &f.unhealthy}, nil
+}
+
+var (
+	listFactory     = &listTestFactory{}
+	registerFactory sync.Once
+)
+
+func TestListBackends(t *testing.T) {
+	registerFactory.Do(func() { backends.Register("listtest", listFactory) })
+
+	config := backends.NewConfig()
+	config.Set("listtest", "enabled", "true")
+	backends.SetBackendConfigs(config)
+	defer backends.SetBackendConfigs(backends.NewConfig())
+	atomic.StoreInt32(&listFactory.created, 0)
+	atomic.StoreInt32(&listFactory.unhealthy, 0)
+
+	router := NewRouter()
+
+	list := func() (map[string]backend, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1-secrets/backends", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Listing backends failed with %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		collection := struct {
+			Data []backend `json:"data"`
+		}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &collection); err != nil {
+			t.Fatal(err)
+		}
+
+		listed := map[string]backend{}
+		for _, b := range collection.Data {
+			listed[b.Name] = b
+		}
+		return listed, recorder.Body.String()
+	}
+
+	listed, _ := list()
+	if b := listed["listtest"]; !b.Configured || b.ClientCreated || b.Healthy {
+		t.Errorf("Expected a configured backend without a client, got %+v", b)
+	}
+	if b, ok := listed["localkey"]; !ok || b.Configured {
+		t.Errorf("Expected localkey to be listed as not configured, got %+v", b)
+	}
+
+	if created := atomic.LoadInt32(&listFactory.created); created != 0 {
+		t.Errorf("Expected listing not to create clients, %d were created", created)
+	}
+
+	if _, err := backends.New("listtest"); err != nil {
+		t.Fatal(err)
+	}
+
+	listed, _ = list()
+	if b := listed["listtest"]; !b.ClientCreated || !b.Healthy {
+		t.Errorf("Expected a healthy client, got %+v", b)
+	}
+
+	atomic.StoreInt32(&listFactory.unhealthy, 1)
+
+	listed, body := list()
+	if b := listed["listtest"]; !b.ClientCreated || b.Healthy {
+		t.Errorf("Expected an unhealthy client, got %+v", b)
+	}
+	if strings.Contains(body, "vault.internal") {
+		t.Errorf("Expected the health error not to be returned: %s", body)
+	}
+}
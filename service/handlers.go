@@ -2,12 +2,15 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/secrets-api/backends"
+	"github.com/rancher/secrets-api/policy"
 	"github.com/rancher/secrets-api/secrets"
 )
 
@@ -17,6 +20,90 @@ type errObj struct {
 	Message string `json:"message,omitempty"`
 }
 
+type backend struct {
+	client.Resource
+	Name       string `json:"name"`
+	Configured bool   `json:"configured"`
+	// ClientCreated is set once a request used the backend, Healthy
+	// reports the state of that client
+	ClientCreated bool `json:"clientCreated"`
+	Healthy       bool `json:"healthy"`
+}
+
+type backendCollection struct {
+	client.Collection
+	Data []backend `json:"data,omitempty"`
+}
+
+// ListBackends lists the registered backends and whether they are configured
+func ListBackends(w http.ResponseWriter, r *http.Request) (int, error) {
+	apiContext := api.GetApiContext(r)
+	backendList := &backendCollection{
+		Collection: client.Collection{
+			ResourceType: "backend",
+		},
+	}
+
+	for _, name := range backends.Registered() {
+		backendList.Data = append(backendList.Data, newBackendResource(name))
+	}
+
+	apiContext.Write(backendList)
+
+	return http.StatusOK, nil
+}
+
+// GetBackend shows a single registered backend
+func GetBackend(w http.ResponseWriter, r *http.Request) (int, error) {
+	apiContext := api.GetApiContext(r)
+	name := mux.Vars(r)["id"]
+
+	for _, registered := range backends.Registered() {
+		if registered == name {
+			resource := newBackendResource(name)
+			apiContext.Write(&resource)
+			return http.StatusOK, nil
+		}
+	}
+
+	return http.StatusNotFound, errors.New("Not found")
+}
+
+// GetServerKey publishes the public key rewrap envelopes are signed with
+func GetServerKey(w http.ResponseWriter, r *http.Request) (int, error) {
+	apiContext := api.GetApiContext(r)
+
+	serverKey := secrets.GetServerKey()
+	if serverKey == nil {
+		return http.StatusNotFound, errors.New("No server key configured")
+	}
+
+	apiContext.Write(serverKey)
+	return http.StatusOK, nil
+}
+
+func newBackendResource(name string) backend {
+	resource := backend{
+		Resource: client.Resource{
+			Id:   name,
+			Type: "backend",
+		},
+		Name:       name,
+		Configured: backends.IsConfigured(name),
+	}
+
+	// Listing backends must not log in to them, and the health error may
+	// name internal endpoints, so it is only logged
+	created, err := backends.Health(name)
+	if err != nil {
+		log.Errorf("Backend %s is unhealthy: %v", name, err)
+	}
+	resource.ClientCreated = created
+	resource.Healthy = created && err == nil
+
+	return resource
+}
+
 // ListSecrets to make schemas work better
 func ListSecrets(w http.ResponseWriter, r *http.Request) (int, error) {
 	apiContext := api.GetApiContext(r)
@@ -26,12 +113,16 @@ func ListSecrets(w http.ResponseWriter, r *http.Request) (int, error) {
 		},
 	}
 	secretCollection.Actions = map[string]string{
-		"create":             apiContext.UrlBuilder.Collection("secret") + "/create",
-		"rewrap":             apiContext.UrlBuilder.Collection("secret") + "/rewrap",
-		"purge":              apiContext.UrlBuilder.Collection("secret") + "/purge",
-		"rewrap?action=bulk": apiContext.UrlBuilder.Collection("secret") + "/rewrap?action=bulk",
-		"create?action=bulk": apiContext.UrlBuilder.Collection("secret") + "/create?action=bulk",
-		"purge?action=bulk":  apiContext.UrlBuilder.Collection("secret") + "/purge?action=bulk",
+		"create":                apiContext.UrlBuilder.Collection("secret") + "/create",
+		"rewrap":                apiContext.UrlBuilder.Collection("secret") + "/rewrap",
+		"purge":                 apiContext.UrlBuilder.Collection("secret") + "/purge",
+		"reencrypt":             apiContext.UrlBuilder.Collection("secret") + "/reencrypt",
+		"migrate":               apiContext.UrlBuilder.Collection("secret") + "/migrate",
+		"rewrap?action=bulk":    apiContext.UrlBuilder.Collection("secret") + "/rewrap?action=bulk",
+		"create?action=bulk":    apiContext.UrlBuilder.Collection("secret") + "/create?action=bulk",
+		"purge?action=bulk":     apiContext.UrlBuilder.Collection("secret") + "/purge?action=bulk",
+		"reencrypt?action=bulk": apiContext.UrlBuilder.Collection("secret") + "/reencrypt?action=bulk",
+		"migrate?action=bulk":   apiContext.UrlBuilder.Collection("secret") + "/migrate?action=bulk",
 	}
 
 	apiContext.Write(secretCollection)
@@ -49,15 +140,18 @@ func CreateSecret(w http.ResponseWriter, r *http.Request) (int, error) {
 
 	err := jsonDecoder.Decode(&sec)
 	if err != nil {
-		logrus.Errorf("Could not decode: %s because %s", r.Body, err)
+		log.Errorf("Could not decode request: %v", err)
 		return http.StatusBadRequest, err
 	}
 
+	sec.SetCaller(CallerIdentity(r))
+
 	secret, err := secrets.NewEncryptedSecret(sec)
+	auditCreate(r, false, []*secrets.UnencryptedSecret{sec}, err)
 	if err != nil {
-		logrus.Errorf("Could not encrypt secret")
-		logrus.Error(err)
-		return http.StatusBadRequest, err
+		log.Errorf("Could not encrypt secret")
+		log.Error(err)
+		return secretsErrorCode(err), err
 	}
 
 	apiContext.Write(&secret)
@@ -77,10 +171,13 @@ func BulkCreateSecret(w http.ResponseWriter, r *http.Request) (int, error) {
 		return http.StatusBadRequest, err
 	}
 
+	bulkSecret.SetCaller(CallerIdentity(r))
+
 	bulkSecrets, err := secrets.NewBulkEncryptedSecret(bulkSecret)
+	auditCreate(r, true, bulkSecret.Data, err)
 	if err != nil {
-		logrus.Error(err)
-		return http.StatusBadRequest, err
+		log.Error(err)
+		return secretsErrorCode(err), err
 	}
 
 	apiContext.Write(bulkSecrets)
@@ -97,14 +194,17 @@ func RewrapSecret(w http.ResponseWriter, r *http.Request) (int, error) {
 
 	err := jsonDecoder.Decode(&sec)
 	if err != nil {
-		logrus.Errorf("Could not decode: %s because %s", r.Body, err)
+		log.Errorf("Could not decode request: %v", err)
 		return http.StatusBadRequest, err
 	}
 
+	sec.SetCaller(CallerIdentity(r))
+
 	secret, err := secrets.NewRewrappedSecret(sec)
+	auditEncrypted(r, policy.ActionRewrap, false, []*secrets.EncryptedSecret{sec}, sec.RewrapKey, "", "", err)
 	if err != nil {
-		logrus.Errorf("Could not rewrap secret")
-		return http.StatusBadRequest, err
+		log.Errorf("Could not rewrap secret")
+		return secretsErrorCode(err), err
 	}
 
 	apiContext.Write(&secret)
@@ -120,20 +220,129 @@ func BulkRewrapSecret(w http.ResponseWriter, r *http.Request) (int, error) {
 
 	err := jsonDecoder.Decode(&bulkSecret)
 	if err != nil {
-		logrus.Errorf("Could not decode: %s because %s", r.Body, err)
+		log.Errorf("Could not decode request: %v", err)
 		return http.StatusBadRequest, err
 	}
 
+	bulkSecret.SetCaller(CallerIdentity(r))
+
 	bulkRewrapped, err := secrets.NewBulkRewrappedSecret(bulkSecret)
+	auditEncrypted(r, policy.ActionRewrap, true, bulkSecret.Data, bulkSecret.RewrapKey, "", "", err)
 	if err != nil {
-		logrus.Error(err)
-		return http.StatusBadRequest, err
+		log.Error(err)
+		return secretsErrorCode(err), err
 	}
 
 	apiContext.Write(&bulkRewrapped)
 	return http.StatusOK, nil
 }
 
+// ReencryptSecret seals an encrypted secret again under the current key version
+func ReencryptSecret(w http.ResponseWriter, r *http.Request) (int, error) {
+	apiContext := api.GetApiContext(r)
+
+	sec := secrets.GetEncryptedSecretResource()
+
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	err := jsonDecoder.Decode(&sec)
+	if err != nil {
+		log.Errorf("Could not decode request: %v", err)
+		return http.StatusBadRequest, err
+	}
+
+	sec.SetCaller(CallerIdentity(r))
+
+	secret, err := secrets.NewReencryptedSecret(sec)
+	auditEncrypted(r, policy.ActionReencrypt, false, []*secrets.EncryptedSecret{sec}, "", "", "", err)
+	if err != nil {
+		log.Errorf("Could not reencrypt secret")
+		return secretsErrorCode(err), err
+	}
+
+	apiContext.Write(secret)
+	return http.StatusOK, nil
+}
+
+// BulkReencryptSecret seals multiple encrypted secrets again under the current key version
+func BulkReencryptSecret(w http.ResponseWriter, r *http.Request) (int, error) {
+	apiContext := api.GetApiContext(r)
+	bulkSecret := secrets.GetBulkEncryptedSecretResource()
+
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	err := jsonDecoder.Decode(&bulkSecret)
+	if err != nil {
+		log.Errorf("Could not decode request: %v", err)
+		return http.StatusBadRequest, err
+	}
+
+	bulkSecret.SetCaller(CallerIdentity(r))
+
+	bulkReencrypted, err := secrets.NewBulkReencryptedSecret(bulkSecret)
+	auditEncrypted(r, policy.ActionReencrypt, true, bulkSecret.Data, "", "", "", err)
+	if err != nil {
+		log.Error(err)
+		return secretsErrorCode(err), err
+	}
+
+	apiContext.Write(bulkReencrypted)
+	return http.StatusOK, nil
+}
+
+// MigrateSecret seals an encrypted secret with a different backend and key
+func MigrateSecret(w http.ResponseWriter, r *http.Request) (int, error) {
+	apiContext := api.GetApiContext(r)
+
+	sec := secrets.GetEncryptedSecretResource()
+
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	err := jsonDecoder.Decode(&sec)
+	if err != nil {
+		log.Errorf("Could not decode request: %v", err)
+		return http.StatusBadRequest, err
+	}
+
+	sec.SetCaller(CallerIdentity(r))
+
+	secret, err := secrets.NewMigratedSecret(sec)
+	auditEncrypted(r, policy.ActionMigrate, false, []*secrets.EncryptedSecret{sec}, "", "", "", err)
+	if err != nil {
+		log.Errorf("Could not migrate secret")
+		return secretsErrorCode(err), err
+	}
+
+	apiContext.Write(secret)
+	return http.StatusOK, nil
+}
+
+// BulkMigrateSecret seals multiple encrypted secrets with a different backend and key
+func BulkMigrateSecret(w http.ResponseWriter, r *http.Request) (int, error) {
+	apiContext := api.GetApiContext(r)
+	bulkSecret := secrets.GetBulkEncryptedSecretResource()
+
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	err := jsonDecoder.Decode(&bulkSecret)
+	if err != nil {
+		log.Errorf("Could not decode request: %v", err)
+		return http.StatusBadRequest, err
+	}
+
+	bulkSecret.SetCaller(CallerIdentity(r))
+
+	bulkMigrated, err := secrets.NewBulkMigratedSecret(bulkSecret)
+	auditEncrypted(r, policy.ActionMigrate, true, bulkSecret.Data, "", bulkSecret.MigrateBackend, bulkSecret.MigrateKeyName, err)
+	if err != nil {
+		log.Error(err)
+		return secretsErrorCode(err), err
+	}
+
+	apiContext.Write(bulkMigrated)
+	return http.StatusOK, nil
+}
+
 // DeleteSecret provides a hook to the backend to clear out data.
 func DeleteSecret(w http.ResponseWriter, r *http.Request) (int, error) {
 	sec := secrets.GetEncryptedSecretResource()
@@ -142,14 +351,17 @@ func DeleteSecret(w http.ResponseWriter, r *http.Request) (int, error) {
 
 	err := jsonDecoder.Decode(&sec)
 	if err != nil {
-		logrus.Errorf("Could not decode: %s because %s", r.Body, err)
+		log.Errorf("Could not decode request: %v", err)
 		return http.StatusBadRequest, err
 	}
 
+	sec.SetCaller(CallerIdentity(r))
+
 	err = sec.Delete()
+	auditEncrypted(r, policy.ActionPurge, false, []*secrets.EncryptedSecret{sec}, "", "", "", err)
 	if err != nil {
-		logrus.Error(err)
-		return http.StatusBadRequest, err
+		log.Error(err)
+		return secretsErrorCode(err), err
 	}
 
 	return http.StatusNoContent, nil
@@ -163,24 +375,35 @@ func BulkDeleteSecret(w http.ResponseWriter, r *http.Request) (int, error) {
 
 	err := jsonDecoder.Decode(&bulkSecret)
 	if err != nil {
-		logrus.Errorf("Could not decode: %s because %s", r.Body, err)
+		log.Errorf("Could not decode request: %v", err)
 		return http.StatusBadRequest, err
 	}
 
+	bulkSecret.SetCaller(CallerIdentity(r))
+
 	err = bulkSecret.Delete()
+	auditEncrypted(r, policy.ActionPurge, true, bulkSecret.Data, "", "", "", err)
 	if err != nil {
-		logrus.Error(err)
-		return http.StatusBadRequest, err
+		log.Error(err)
+		return secretsErrorCode(err), err
 	}
 
 	return http.StatusNoContent, nil
 }
 
+// secretsErrorCode is the response code for an error from the secrets package
+func secretsErrorCode(err error) int {
+	if _, ok := err.(*policy.DeniedError); ok {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
 //URLEncoded encodes the urls so that spaces are allowed in resource names
 func URLEncoded(str string) string {
 	u, err := url.Parse(str)
 	if err != nil {
-		logrus.Errorf("Error encoding the url: %s , error: %v", str, err)
+		log.Errorf("Error encoding the url: %s , error: %v", str, err)
 		return str
 	}
 	return u.String()
@@ -5,20 +5,22 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/secrets-api/pkg/logutils"
 	"github.com/rancher/secrets-api/secrets"
 )
 
+var log = logutils.New("service")
+
 var schemas *client.Schemas
 
 // HandleError is a wrapper that handles response codes and error messages
 func HandleError(s *client.Schemas, t func(http.ResponseWriter, *http.Request) (int, error)) http.Handler {
 	return api.ApiHandler(s, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		if code, err := t(rw, req); err != nil {
-			logrus.Errorf("Error in request, code : %d: %s", code, err)
+			log.Errorf("Error in request, code : %d: %s", code, err)
 			apiContext := api.GetApiContext(req)
 			rw.WriteHeader(code)
 
@@ -36,7 +38,9 @@ func HandleError(s *client.Schemas, t func(http.ResponseWriter, *http.Request) (
 // NewRouter creates the router for the application and wires up Rancher API spec schema
 func NewRouter() *mux.Router {
 	schemas = &client.Schemas{}
-	f := HandleError
+	f := func(s *client.Schemas, t func(http.ResponseWriter, *http.Request) (int, error)) http.Handler {
+		return HandleError(s, assignRequestID(auditRejections(authenticate(t))))
+	}
 
 	schemas.AddType("apiVersion", client.Resource{})
 	schemas.AddType("schema", client.Schema{})
@@ -49,6 +53,14 @@ func NewRouter() *mux.Router {
 	schemas.AddType("encryptedSecret", secrets.EncryptedSecret{})
 	schemas.AddType("rewrappedSecret", secrets.RewrappedSecret{})
 
+	backend := schemas.AddType("backend", backend{})
+	backend.CollectionMethods = []string{"GET"}
+	backend.ResourceMethods = []string{"GET"}
+
+	serverKey := schemas.AddType("serverKey", secrets.ServerKey{})
+	serverKey.CollectionMethods = []string{}
+	serverKey.ResourceMethods = []string{"GET"}
+
 	secret := schemas.AddType("secret", secrets.Secret{})
 	secret.CollectionMethods = []string{"GET"}
 	secret.CollectionActions = map[string]client.Action{
@@ -76,6 +88,22 @@ func NewRouter() *mux.Router {
 			Input:  "bulkEncryptedSecret",
 			Output: "bulkEncryptedSecret",
 		},
+		"reencrypt": {
+			Input:  "encryptedSecret",
+			Output: "encryptedSecret",
+		},
+		"reencrypt?action=bulk": {
+			Input:  "bulkEncryptedSecret",
+			Output: "bulkEncryptedSecret",
+		},
+		"migrate": {
+			Input:  "encryptedSecret",
+			Output: "encryptedSecret",
+		},
+		"migrate?action=bulk": {
+			Input:  "bulkEncryptedSecret",
+			Output: "bulkEncryptedSecret",
+		},
 	}
 
 	router := mux.NewRouter().StrictSlash(false)
@@ -93,6 +121,13 @@ func NewRouter() *mux.Router {
 	router.Methods("GET").Path("/v1-secrets/secrets").Handler(f(schemas, ListSecrets))
 	router.Methods("GET").Path("/v1-secrets/secrets/").Handler(f(schemas, ListSecrets))
 
+	router.Methods("GET").Path("/v1-secrets/backends").Handler(f(schemas, ListBackends))
+	router.Methods("GET").Path("/v1-secrets/backends/").Handler(f(schemas, ListBackends))
+	router.Methods("GET").Path("/v1-secrets/backends/{id}").Handler(f(schemas, GetBackend))
+
+	router.Methods("GET").Path("/v1-secrets/keys/server").Handler(f(schemas, GetServerKey))
+	router.Methods("GET").Path("/v1-secrets/keys/server/").Handler(f(schemas, GetServerKey))
+
 	err := schemas.AddType("error", errObj{})
 	err.CollectionMethods = []string{}
 
@@ -100,16 +135,16 @@ func NewRouter() *mux.Router {
 	router.Methods("POST").
 		Path("/v1-secrets/secrets/create").
 		Queries("action", "bulk").
-		Handler(f(schemas, BulkCreateSecret))
+		Handler(f(schemas, allowPeers("create", BulkCreateSecret)))
 
-	router.Methods("POST").Path("/v1-secrets/secrets/create").Handler(f(schemas, CreateSecret))
+	router.Methods("POST").Path("/v1-secrets/secrets/create").Handler(f(schemas, allowPeers("create", CreateSecret)))
 
 	router.Methods("POST").
 		Path("/v1-secrets/secrets/rewrap").
 		Queries("action", "bulk").
-		Handler(f(schemas, BulkRewrapSecret))
+		Handler(f(schemas, allowPeers("rewrap", BulkRewrapSecret)))
 
-	router.Methods("POST").Path("/v1-secrets/secrets/rewrap").Handler(f(schemas, RewrapSecret))
+	router.Methods("POST").Path("/v1-secrets/secrets/rewrap").Handler(f(schemas, allowPeers("rewrap", RewrapSecret)))
 
 	router.Methods("POST").
 		Path("/v1-secrets/secrets/purge").
@@ -120,6 +155,20 @@ func NewRouter() *mux.Router {
 		Path("/v1-secrets/secrets/purge").
 		Handler(f(schemas, DeleteSecret))
 
+	router.Methods("POST").
+		Path("/v1-secrets/secrets/reencrypt").
+		Queries("action", "bulk").
+		Handler(f(schemas, allowPeers("reencrypt", BulkReencryptSecret)))
+
+	router.Methods("POST").Path("/v1-secrets/secrets/reencrypt").Handler(f(schemas, allowPeers("reencrypt", ReencryptSecret)))
+
+	router.Methods("POST").
+		Path("/v1-secrets/secrets/migrate").
+		Queries("action", "bulk").
+		Handler(f(schemas, allowPeers("migrate", BulkMigrateSecret)))
+
+	router.Methods("POST").Path("/v1-secrets/secrets/migrate").Handler(f(schemas, allowPeers("migrate", MigrateSecret)))
+
 	// These just loop back to themselves in the schemas
 	router.Methods("GET").Path("/v1-secrets/secrets/create").Handler(f(schemas, ListSecrets))
 	router.Methods("GET").Path("/v1-secrets/secrets/create/").Handler(f(schemas, ListSecrets))
@@ -127,6 +176,12 @@ func NewRouter() *mux.Router {
 	router.Methods("GET").Path("/v1-secrets/secrets/rewrap").Handler(f(schemas, ListSecrets))
 	router.Methods("GET").Path("/v1-secrets/secrets/rewrap/").Handler(f(schemas, ListSecrets))
 
+	router.Methods("GET").Path("/v1-secrets/secrets/reencrypt").Handler(f(schemas, ListSecrets))
+	router.Methods("GET").Path("/v1-secrets/secrets/reencrypt/").Handler(f(schemas, ListSecrets))
+
+	router.Methods("GET").Path("/v1-secrets/secrets/migrate").Handler(f(schemas, ListSecrets))
+	router.Methods("GET").Path("/v1-secrets/secrets/migrate/").Handler(f(schemas, ListSecrets))
+
 	router.Methods("GET").Path("/v1-secrets/secrets/create").Queries("action", "bulk").Handler(f(schemas, ListSecrets))
 	router.Methods("GET").Path("/v1-secrets/secrets/create/").Queries("action", "bulk").Handler(f(schemas, ListSecrets))
 	router.Methods("GET").Path("/v1-secrets/secrets/rewrap").Queries("action", "bulk").Handler(f(schemas, ListSecrets))
@@ -1,11 +1,78 @@
 package service
 
 import (
+	"errors"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 )
 
+// Config holds the listener settings of the API server
+type Config struct {
+	// ListenAddress is host:port, or unix:///path/to/socket
+	ListenAddress string
+
+	// TLSCert and TLSKey enable HTTPS. With TLSClientCA set, clients must
+	// present a certificate signed by one of its CAs.
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+
+	// SocketMode and SocketOwner ("user:group", names or ids) are applied
+	// to a unix socket. The mode defaults to 0660.
+	SocketMode  os.FileMode
+	SocketOwner string
+
+	// CreateUIDs and RewrapUIDs restrict create and rewrap requests made
+	// over a unix socket to these local users. Empty lists allow everyone.
+	CreateUIDs []uint32
+	RewrapUIDs []uint32
+
+	// Authenticators are tried in order on every v1-secrets route but the
+	// schemas. Without any, requests are not authenticated.
+	Authenticators []Authenticator
+}
+
 // StartServer creates and initializes the server api
-func StartServer(listenAddress string) error {
-	router := NewRouter()
-	return http.ListenAndServe(listenAddress, router)
+func StartServer(config Config) error {
+	setPeerAllowList(config)
+	setAuthenticators(config)
+
+	server := &http.Server{
+		Handler:     NewRouter(),
+		ConnContext: peerContext,
+	}
+
+	if config.TLSCert != "" || config.TLSKey != "" {
+		reloader, err := newCertReloader(config)
+		if err != nil {
+			return err
+		}
+		go reloader.watch(DefaultCertReloadInterval)
+
+		// The certificate comes from TLSConfig so it can be reloaded
+		server.TLSConfig = reloader.tlsConfig()
+	} else if config.TLSClientCA != "" {
+		return errors.New("A client CA requires a TLS certificate and key")
+	}
+
+	listener, err := listen(config)
+	if err != nil {
+		return err
+	}
+
+	if server.TLSConfig != nil {
+		return server.ServeTLS(listener, "", "")
+	}
+
+	return server.Serve(listener)
+}
+
+func listen(config Config) (net.Listener, error) {
+	if strings.HasPrefix(config.ListenAddress, unixScheme) {
+		return listenUnix(config)
+	}
+
+	return net.Listen("tcp", config.ListenAddress)
 }
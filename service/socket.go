@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const unixScheme = "unix://"
+
+// PeerCred identifies the local process on the other end of a unix socket
+type PeerCred struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+type peerKey struct{}
+
+// peerAllowList maps an action to the local users allowed to call it over
+// the unix socket
+var peerAllowList = map[string]map[uint32]bool{}
+
+// PeerCredentials returns the credentials of the process that made a request
+// over a unix socket. It returns false for TCP requests and when the
+// credentials could not be read.
+func PeerCredentials(r *http.Request) (*PeerCred, bool) {
+	cred, _ := r.Context().Value(peerKey{}).(*PeerCred)
+	return cred, cred != nil
+}
+
+func setPeerAllowList(config Config) {
+	peerAllowList = map[string]map[uint32]bool{}
+
+	// Reencrypt seals secrets like create does, and migrate hands them to
+	// another key like rewrap does. Purge is not limited, it reveals nothing
+	// and is restricted with a policy instead.
+	for action, uids := range map[string][]uint32{
+		"create":    config.CreateUIDs,
+		"reencrypt": config.CreateUIDs,
+		"rewrap":    config.RewrapUIDs,
+		"migrate":   config.RewrapUIDs,
+	} {
+		if len(uids) == 0 {
+			continue
+		}
+
+		peerAllowList[action] = map[uint32]bool{}
+		for _, uid := range uids {
+			peerAllowList[action][uid] = true
+		}
+	}
+}
+
+// allowPeers rejects socket requests for action from users that are not on
+// its allow-list. TCP requests are not affected.
+func allowPeers(action string, handler func(http.ResponseWriter, *http.Request) (int, error)) func(http.ResponseWriter, *http.Request) (int, error) {
+	return func(w http.ResponseWriter, r *http.Request) (int, error) {
+		cred, viaSocket := r.Context().Value(peerKey{}).(*PeerCred)
+		allowed := peerAllowList[action]
+
+		if viaSocket && len(allowed) > 0 && (cred == nil || !allowed[cred.UID]) {
+			return http.StatusForbidden, fmt.Errorf("Local user is not allowed to %s secrets", action)
+		}
+
+		return handler(w, r)
+	}
+}
+
+// peerContext records the peer credentials of unix socket connections
+func peerContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	cred, err := peerCredentials(unixConn)
+	if err != nil {
+		log.Errorf("Could not read peer credentials: %v", err)
+	}
+
+	// A nil cred still marks the connection as coming over the socket
+	return context.WithValue(ctx, peerKey{}, cred)
+}
+
+func listenUnix(config Config) (net.Listener, error) {
+	socketPath := strings.TrimPrefix(config.ListenAddress, unixScheme)
+
+	// Remove a socket left behind by a previous run, but nothing else
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := listenUnixSocket(socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := config.SocketMode
+	if mode == 0 {
+		mode = 0660
+	}
+
+	if err := os.Chmod(socketPath, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if config.SocketOwner != "" {
+		uid, gid, err := lookupOwner(config.SocketOwner)
+		if err == nil {
+			err = os.Chown(socketPath, uid, gid)
+		}
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// lookupOwner resolves "user", "user:group" or ":group", given as names or
+// ids. An omitted part is returned as -1, which chown leaves unchanged.
+func lookupOwner(owner string) (int, int, error) {
+	parts := strings.SplitN(owner, ":", 2)
+	uid, gid := -1, -1
+
+	if parts[0] != "" {
+		id, err := LookupUID(parts[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		uid = int(id)
+	}
+
+	if len(parts) == 2 && parts[1] != "" {
+		if id, err := strconv.Atoi(parts[1]); err == nil {
+			gid = id
+		} else {
+			group, err := user.LookupGroup(parts[1])
+			if err != nil {
+				return 0, 0, err
+			}
+			if gid, err = strconv.Atoi(group.Gid); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// LookupUID resolves a user name or numeric id to a uid
+func LookupUID(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("User %s has no numeric uid", name)
+	}
+
+	return uint32(id), nil
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnixSocket creates the socket with no permissions for group and
+// others, the configured mode is applied afterwards
+func listenUnixSocket(socketPath string) (net.Listener, error) {
+	oldMask := syscall.Umask(0177)
+	defer syscall.Umask(oldMask)
+
+	return net.Listen("unix", socketPath)
+}
+
+func peerCredentials(conn *net.UnixConn) (*PeerCred, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	return &PeerCred{
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+		PID: ucred.Pid,
+	}, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package service
+
+import (
+	"errors"
+	"net"
+)
+
+func listenUnixSocket(socketPath string) (net.Listener, error) {
+	return net.Listen("unix", socketPath)
+}
+
+func peerCredentials(conn *net.UnixConn) (*PeerCred, error) {
+	return nil, errors.New("Peer credentials are only supported on Linux")
+}
@@ -0,0 +1,136 @@
+//go:build linux
+
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestUnixSocketPeerAllowList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := path.Join(dir, "secrets-api.sock")
+	config := Config{
+		ListenAddress: unixScheme + socketPath,
+		SocketMode:    0600,
+		CreateUIDs:    []uint32{uint32(os.Getuid()) + 1},
+		RewrapUIDs:    []uint32{uint32(os.Getuid()) + 1},
+	}
+
+	listener, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setPeerAllowList(config)
+	defer setPeerAllowList(Config{})
+
+	server := &http.Server{Handler: NewRouter(), ConnContext: peerContext}
+	go server.Serve(listener)
+	defer server.Close()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected socket mode 0600, got %o", info.Mode().Perm())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socketPath)
+		},
+	}}
+
+	post := func(action, body string) int {
+		resp, err := client.Post("http://unix/v1-secrets/secrets/"+action, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	created := `{"backend": "none", "clearText": "aGVsbG8="}`
+	for _, action := range []string{"create", "reencrypt", "rewrap", "migrate"} {
+		if code := post(action, created); code != http.StatusForbidden {
+			t.Errorf("Expected a user off the allow-list to get 403 for %s, got %d", action, code)
+		}
+	}
+
+	config.CreateUIDs = append(config.CreateUIDs, uint32(os.Getuid()))
+	setPeerAllowList(config)
+
+	if code := post("create", created); code != http.StatusOK {
+		t.Errorf("Expected an allowed user to create secrets, got %d", code)
+	}
+	if code := post("migrate", created); code != http.StatusForbidden {
+		t.Errorf("Expected migrate to follow the rewrap allow-list, got %d", code)
+	}
+
+	// A socket left behind by a previous run is replaced
+	stale, err := net.Listen("unix", path.Join(dir, "stale.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	config.ListenAddress = unixScheme + path.Join(dir, "stale.sock")
+	listener, err = listen(config)
+	if err != nil {
+		t.Fatalf("Expected a stale socket to be replaced: %v", err)
+	}
+	listener.Close()
+}
+
+func TestPeerCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	listener, err := net.Listen("unix", path.Join(dir, "peer.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", path.Join(dir, "peer.sock")); err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := peerContext(context.Background(), conn)
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	cred, ok := PeerCredentials(req.WithContext(ctx))
+	if !ok {
+		t.Fatal("Expected peer credentials for a unix socket connection")
+	}
+
+	if cred.UID != uint32(os.Getuid()) || cred.PID != int32(os.Getpid()) {
+		t.Errorf("Unexpected peer credentials %+v", cred)
+	}
+}
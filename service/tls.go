@@ -0,0 +1,145 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is how often the certificate files are checked
+// for changes
+const DefaultCertReloadInterval = 10 * time.Second
+
+// certReloader serves the certificate and client CAs from files, loading
+// them again when a file changes so renewed certificates apply without a
+// restart
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func newCertReloader(config Config) (*certReloader, error) {
+	if config.TLSCert == "" || config.TLSKey == "" {
+		return nil, errors.New("Both a TLS certificate and key are required")
+	}
+
+	reloader := &certReloader{
+		certFile:     config.TLSCert,
+		keyFile:      config.TLSKey,
+		clientCAFile: config.TLSClientCA,
+	}
+
+	return reloader, reloader.reload()
+}
+
+// tlsConfig returns a server config that picks up reloaded certificates on
+// each new connection
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.configForClient,
+	}
+}
+
+func (r *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+	}
+
+	if r.clientCA != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = r.clientCA
+	}
+
+	return config, nil
+}
+
+// reload loads the files if any of them changed. On error the previous
+// certificate stays in use.
+func (r *certReloader) reload() error {
+	modTimes, changed, err := r.changedFiles()
+	if err != nil || !changed {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCA *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificates found in %s", r.clientCAFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cert = &cert
+	r.clientCA = clientCA
+	r.modTimes = modTimes
+
+	log.Infof("Loaded TLS certificate from %s", r.certFile)
+	return nil
+}
+
+func (r *certReloader) changedFiles() (map[string]time.Time, bool, error) {
+	modTimes := map[string]time.Time{}
+	for _, file := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, false, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.modTimes == nil {
+		return modTimes, true, nil
+	}
+
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return modTimes, true, nil
+		}
+	}
+
+	return modTimes, false, nil
+}
+
+func (r *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := r.reload(); err != nil {
+			log.Errorf("Could not reload TLS certificate: %v", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, name string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string, modTime time.Time) {
+	for file, data := range map[string][]byte{name + ".crt": c.certPEM, name + ".key": c.keyPEM} {
+		if err := ioutil.WriteFile(path.Join(dir, file), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path.Join(dir, file), modTime, modTime)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", 1, nil)
+	ca.write(t, dir, "ca", time.Now())
+	newTestCert(t, "server", 2, ca).write(t, dir, "server", time.Now().Add(-time.Minute))
+
+	reloader, err := newCertReloader(Config{
+		TLSCert:     path.Join(dir, "server.crt"),
+		TLSKey:      path.Join(dir, "server.key"),
+		TLSClientCA: path.Join(dir, "ca.crt"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = reloader.tlsConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	clientCert := newTestCert(t, "client", 3, ca)
+	keyPair, err := tls.X509KeyPair(clientCert.certPEM, clientCert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(certs []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		return client.Get(server.URL)
+	}
+
+	if _, err := get(nil); err == nil {
+		t.Error("Expected a client without a certificate to be rejected")
+	}
+
+	resp, err := get([]tls.Certificate{keyPair})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "client" {
+		t.Errorf("Expected the client certificate to reach the handler, got %q", body)
+	}
+
+	// A renewed server certificate is served after a reload
+	renewed := newTestCert(t, "server", 4, ca)
+	renewed.write(t, dir, "server", time.Now())
+
+	if err := reloader.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = get([]tls.Certificate{keyPair})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Errorf("Expected the renewed certificate, got serial %d", serial)
+	}
+}